@@ -0,0 +1,69 @@
+// anc command groups the Adaptive Network Control sub-commands, they apply or clear ISE ANC policies
+// on an endpoint selected by its MAC or IP address, for example to quarantine an endpoint
+
+package cmd
+
+import (
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var (
+	ancPolicyName string
+	ancMacAddress string
+	ancIpAddress  string
+	ancSourceName string
+)
+
+// ancCmd represents the anc command
+var ancCmd = &cobra.Command{
+	Use:   "anc",
+	Short: "Cisco ISE Adaptive Network Control",
+	Long:  `Apply or clear ISE ANC policies on endpoints. sub-commands {policies, apply, clear}`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+		os.Exit(0)
+	},
+}
+
+func init() {
+	pxgridCmd.AddCommand(ancCmd)
+	ancCmd.PersistentFlags().StringVarP(&ancSourceName, "source", "", "", "the name of the ISE source in ISE_SOURCES, default is the first source")
+}
+
+// getANCController look up the ANC service of the selected ISE source or exit
+func getANCController() *lib.ANCController {
+	sources, err := lib.NewISESources()
+	if err != nil {
+		logrus.Error(err)
+		logrus.Exit(1)
+	}
+	source, err := lib.FindISESource(sources, ancSourceName)
+	if err != nil {
+		logrus.Error(err)
+		logrus.Exit(1)
+	}
+	if err := source.Validate(); err != nil {
+		logrus.Error(err)
+		logrus.Exit(1)
+	}
+	controller, err := lib.GetController(source)
+	if err != nil {
+		logrus.Error(err)
+		logrus.Exit(1)
+	}
+	ancController, err := lib.NewANCController(controller)
+	if err != nil {
+		logrus.Error(err)
+		logrus.Exit(1)
+	}
+	return ancController
+}
+
+// addEndpointSelectorFlags add the --mac and --ip flags to a command
+func addEndpointSelectorFlags(command *cobra.Command) {
+	command.Flags().StringVarP(&ancMacAddress, "mac", "m", "", "the endpoint MAC address")
+	command.Flags().StringVarP(&ancIpAddress, "ip", "", "", "the endpoint IP address")
+}
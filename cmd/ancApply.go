@@ -0,0 +1,32 @@
+// apply command applies an ANC policy to an endpoint
+
+package cmd
+
+import (
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var ancApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply an ANC policy to an endpoint",
+	Long:  `Apply the ANC policy (--policy) to the endpoint selected by its MAC address (--mac) or IP address (--ip)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := getANCController().Apply(&lib.ANCEndpointInput{PolicyName: ancPolicyName, MacAddress: ancMacAddress, IpAddress: ancIpAddress})
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		printJSON(status)
+	},
+}
+
+func init() {
+	ancCmd.AddCommand(ancApplyCmd)
+	addEndpointSelectorFlags(ancApplyCmd)
+	ancApplyCmd.Flags().StringVarP(&ancPolicyName, "policy", "p", "", "the ANC policy name")
+	if err := ancApplyCmd.MarkFlagRequired("policy"); err != nil {
+		logrus.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,29 @@
+// clear command clears the ANC policy of an endpoint
+
+package cmd
+
+import (
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var ancClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear the ANC policy of an endpoint",
+	Long:  `Clear the ANC policy of the endpoint selected by its MAC address (--mac) or IP address (--ip)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := getANCController().Clear(&lib.ANCEndpointInput{PolicyName: ancPolicyName, MacAddress: ancMacAddress, IpAddress: ancIpAddress})
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		printJSON(status)
+	},
+}
+
+func init() {
+	ancCmd.AddCommand(ancClearCmd)
+	addEndpointSelectorFlags(ancClearCmd)
+	ancClearCmd.Flags().StringVarP(&ancPolicyName, "policy", "p", "", "the ANC policy name, optional")
+}
@@ -0,0 +1,26 @@
+// policies command lists the ANC policies defined in ISE
+
+package cmd
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var ancPoliciesCmd = &cobra.Command{
+	Use:   "policies",
+	Short: "List the ISE ANC policies",
+	Long:  `Print the ANC policies defined in ISE and their actions as JSON`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policies, err := getANCController().GetPolicies()
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		printJSON(policies)
+	},
+}
+
+func init() {
+	ancCmd.AddCommand(ancPoliciesCmd)
+}
@@ -1,14 +1,17 @@
 // the consumer command subscribe for cisco ISE pxGrid service.
-//watches the ISE session events and takes required actions to add or remove users ip address using FUID API
+//watches the ISE session events and takes required actions to add or remove users ip address using FUID API.
+//every ISE source of ISE_SOURCES is polled concurrently and delivers its sessions to the shared identity sinks,
+//a source failing with a non-transient error is stopped and the consumer exits once every source is stopped
 
 package cmd
 
 import (
 	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"os"
+	"sync"
 	"time"
 )
 
@@ -18,66 +21,142 @@ var consumerRestCmd = &cobra.Command{
 	Short: "subscribe for sessions events using the REST API",
 	Long:  `watch session events and take action for AUTHENTICATED and DISCONNECT events`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := lib.ValidateUsernamePassword(); err != nil {
-			logrus.Error(err)
-			logrus.Exit(1)
-		}
-		fuidController, err := lib.NewFUIDController()
+		sources, err := lib.NewISESources()
 		if err != nil {
 			logrus.Error(err)
 			logrus.Exit(1)
 		}
-		createClient := lib.CreateClient{NodeName: viper.GetString("PXGRID_CLIENT_ACCOUNT_NAME")}
-		controller, err := lib.GetController()
-		if err != nil {
-			logrus.Error(err)
-			os.Exit(1)
+		controllers := make(map[string]*lib.Controller, len(sources))
+		for _, source := range sources {
+			controllers[source.Name] = activateISESource(source)
 		}
-		accountActivate, err := createClient.AccountActivate(controller)
+		// the TrustSec and ANC services are used on the ISE source named in ISE_SERVICES_SOURCE, the first source by default
+		servicesSource, err := lib.FindISESource(sources, viper.GetString("ISE_SERVICES_SOURCE"))
 		if err != nil {
 			logrus.Error(err)
 			logrus.Exit(1)
 		}
-		if accountActivate.AccountState != lib.Enabled {
-			logrus.Errorf("the status of the client account is %s, please contact your Cisco ISE Administrator to aprove or enable it", accountActivate.AccountState)
-			logrus.Exit(1)
-		}
-		if DisplayProcess {
-			logrus.Infof("PxGrid API Client Account %s is Activated and Enabled", createClient.NodeName)
-		}
-		//do service lookup
-		serviceLookupOutput, err := lib.ServiceLookupRequest(lib.ServiceLookupSessions, controller)
+		controller := controllers[servicesSource.Name]
+		sinks, err := lib.NewIdentitySinks(controller, DisplayProcess)
 		if err != nil {
 			logrus.Error(err)
 			logrus.Exit(1)
 		}
-		if DisplayProcess {
-			logrus.Infof("service lookup: found %d services", len(serviceLookupOutput.Services))
+		if viper.GetBool("LOCAL_API_ENABLED") {
+			localApi, err := lib.NewLocalAPI(sinks.Index())
+			if err != nil {
+				logrus.Error(err)
+				logrus.Exit(1)
+			}
+			// the ANC actions let the incidents quarantine an endpoint through ISE
+			if viper.GetBool("ANC_ENABLED") {
+				ancController, err := lib.NewANCController(controller)
+				if err != nil {
+					logrus.Error(err)
+					logrus.Exit(1)
+				}
+				if err := ancController.RegisterHandlers(localApi); err != nil {
+					logrus.Error(err)
+					logrus.Exit(1)
+				}
+			}
+			if err := localApi.Start(); err != nil {
+				logrus.Error(err)
+				logrus.Exit(1)
+			}
 		}
-		restBaseUrl, nodeName, err := lib.GetSessionRestUrl(serviceLookupOutput.Services)
+		// in HA mode only the leader polls ISE and writes the identities, the checkpoint files are shared
+		elector, err := lib.NewLeaderElectorFromConfig()
 		if err != nil {
 			logrus.Error(err)
 			logrus.Exit(1)
 		}
-		if DisplayProcess {
-			logrus.Infof("Using restBaseUrl: %s", restBaseUrl)
-		}
-		accessSecretOutput, err := lib.AccessSecret(nodeName, controller)
-		if err != nil {
-			logrus.Error(err)
-			logrus.Exit(1)
+		if elector != nil {
+			elector.Start()
 		}
 		lib.SetupCloseHandler()
-		for {
-			if err := lib.SessionListener(accessSecretOutput.Secret, restBaseUrl, viper.GetString("SESSION_LATEST_TIMESTAMP_PATH"), controller, fuidController, DisplayProcess); err != nil {
-				logrus.Error(err)
-				logrus.Exit(1)
-			}
-			time.Sleep(time.Duration(viper.GetInt("SESSION_LISTENER_INTERVAL_TIME")) * time.Second)
+		// a source failing with a non-transient error is stopped, the other sources keep polling
+		var running sync.WaitGroup
+		for _, source := range sources {
+			running.Add(1)
+			go func(source *lib.ISESource) {
+				defer running.Done()
+				if err := listenISESource(source, controllers[source.Name], sinks, elector); err != nil {
+					source.Log().Errorf("the source is stopped: %s", err)
+				}
+			}(source)
 		}
+		running.Wait()
+		logrus.Error("every ISE source is stopped")
+		logrus.Exit(1)
 	},
 }
 
 func init() {
 	pxgridCmd.AddCommand(consumerRestCmd)
 }
+
+// activateISESource create the controller of a source and check its client account is enabled, or exit
+func activateISESource(source *lib.ISESource) *lib.Controller {
+	log := source.Log()
+	if err := source.Validate(); err != nil {
+		log.Error(err)
+		logrus.Exit(1)
+	}
+	createClient := lib.CreateClient{NodeName: source.ClientAccountName}
+	controller, err := lib.GetController(source)
+	if err != nil {
+		log.Error(err)
+		logrus.Exit(1)
+	}
+	accountActivate, err := createClient.AccountActivate(controller)
+	if err != nil {
+		log.Error(err)
+		logrus.Exit(1)
+	}
+	if accountActivate.AccountState != lib.Enabled {
+		log.Errorf("the status of the client account is %s, please contact your Cisco ISE Administrator to aprove or enable it", accountActivate.AccountState)
+		logrus.Exit(1)
+	}
+	if DisplayProcess {
+		log.Infof("PxGrid API Client Account %s is Activated and Enabled", createClient.NodeName)
+	}
+	return controller
+}
+
+// listenISESource look up the session service of a source and poll it, the error stopping the source is returned
+func listenISESource(source *lib.ISESource, controller *lib.Controller, sinks *lib.SinkSet, elector *lib.LeaderElector) error {
+	log := source.Log()
+	//do service lookup
+	serviceLookupOutput, err := lib.ServiceLookupRequest(lib.ServiceLookupSessions, controller)
+	if err != nil {
+		return err
+	}
+	if DisplayProcess {
+		log.Infof("service lookup: found %d services", len(serviceLookupOutput.Services))
+	}
+	restBaseUrl, nodeName, err := lib.GetSessionRestUrl(serviceLookupOutput.Services)
+	if err != nil {
+		return err
+	}
+	if DisplayProcess {
+		log.Infof("Using restBaseUrl: %s", restBaseUrl)
+	}
+	accessSecretOutput, err := lib.AccessSecret(nodeName, controller)
+	if err != nil {
+		return err
+	}
+	for {
+		if elector.IsLeader() {
+			if err := lib.SessionListener(accessSecretOutput.Secret, restBaseUrl, controller, sinks, elector, DisplayProcess); err != nil {
+				// ISE or FUID hiccups are retried in the next poll, the timestamp was not advanced.
+				// a poll aborted by the loss of the HA leadership is polled again by the new leader
+				if !lib.IsTransient(err) && !errors.Is(err, lib.ErrLeadershipLost) {
+					return err
+				}
+				log.Warn(err)
+			}
+		}
+		time.Sleep(time.Duration(viper.GetInt("SESSION_LISTENER_INTERVAL_TIME")) * time.Second)
+	}
+}
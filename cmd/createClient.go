@@ -21,8 +21,13 @@ var createClientCmd = &cobra.Command{
 	Long: `Creating Username & Password for Client Registration, Once the client is created the ISE
 administrator needs to approve the created client account`,
 	Run: func(cmd *cobra.Command, args []string) {
-		createClient := lib.CreateClient{NodeName: viper.GetString("PXGRID_CLIENT_ACCOUNT_NAME")}
-		controller, err := lib.GetController()
+		source, err := lib.DefaultISESource()
+		if err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+		createClient := lib.CreateClient{NodeName: source.ClientAccountName}
+		controller, err := lib.GetController(source)
 		if err != nil {
 			logrus.Error(err)
 			os.Exit(1)
@@ -35,8 +40,8 @@ administrator needs to approve the created client account`,
 		if DisplayProcess {
 			logrus.Infof("Created  pxGrid client ccount with name '%s'", createClient.NodeName)
 		}
-		viper.Set("PXGRID_CLIENT_ACCOUNT_NAME", iseClient.UserName)
-		viper.Set("PXGRID_CLIENT_ACCOUNT_PASSWORD", iseClient.Password)
+		source.ClientAccountName = iseClient.UserName
+		source.ClientAccountPassword = iseClient.Password
 		time.Sleep(3 * time.Second)
 		accountActivate, err := createClient.AccountActivate(controller)
 		if err != nil {
@@ -0,0 +1,81 @@
+// fuid command groups the administration sub-commands for the Forcepoint User ID service.
+// the sub-commands use the same FUID TLS and authentication settings as the consumer command
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var (
+	fuidNTLMIdentity string
+	fuidObjectGUID   string
+)
+
+// fuidCmd represents the fuid command
+var fuidCmd = &cobra.Command{
+	Use:   "fuid",
+	Short: "Forcepoint User ID service administration",
+	Long:  `Inspect and fix the FUID users database. sub-commands {get-user, list-users, add-ip, remove-ip, delete-user, export, api-version}`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+		os.Exit(0)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fuidCmd)
+}
+
+// getFUIDController create a FUID controller or exit
+func getFUIDController() *lib.FUIDController {
+	fuidController, err := lib.NewFUIDController()
+	if err != nil {
+		logrus.Error(err)
+		logrus.Exit(1)
+	}
+	return fuidController
+}
+
+// addUserSelectorFlags add the --ntlm-identity and --guid flags to a command
+func addUserSelectorFlags(command *cobra.Command) {
+	command.Flags().StringVarP(&fuidNTLMIdentity, "ntlm-identity", "n", "", "the user NTLM identity in format DOMAIN\\username")
+	command.Flags().StringVarP(&fuidObjectGUID, "guid", "g", "", "the user objectGUID")
+}
+
+// lookupFUIDUser read a user from FUID by its NTLM identity or objectGUID
+func lookupFUIDUser(fuidController *lib.FUIDController) (*lib.FUIDUser, error) {
+	if fuidNTLMIdentity == "" && fuidObjectGUID == "" {
+		return nil, errors.New("one of --ntlm-identity or --guid is required")
+	}
+	if fuidNTLMIdentity != "" && fuidObjectGUID != "" {
+		return nil, errors.New("only one of --ntlm-identity or --guid can be provided")
+	}
+	var user *lib.FUIDUser
+	var err error
+	if fuidObjectGUID != "" {
+		user, err = fuidController.GetUserByGUID(fuidObjectGUID)
+	} else {
+		user, err = fuidController.GetUser(fuidNTLMIdentity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// printJSON print a value as indented JSON
+func printJSON(value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		logrus.Error(err)
+		logrus.Exit(1)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,45 @@
+// add-ip command adds IP addresses to a user in the FUID database
+
+package cmd
+
+import (
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var fuidAddIpAddresses []string
+
+var fuidAddIpCmd = &cobra.Command{
+	Use:   "add-ip",
+	Short: "Add IP addresses to a FUID user",
+	Long:  `Add one or more IP addresses (--ip) to a user selected by its NTLM identity (--ntlm-identity) or objectGUID (--guid)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fuidController := getFUIDController()
+		user, err := lookupFUIDUser(fuidController)
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		changeType := lib.ChangeTypeAdd
+		if len(user.Ipv4Addresses) != 0 {
+			changeType = lib.ChangeTypeModify
+		}
+		if err := fuidController.UpdateUserIPs(user.ObjectGUID, changeType, fuidAddIpAddresses); err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		if DisplayProcess {
+			logrus.Infof("added IP addresses %v to user %s", fuidAddIpAddresses, user.NTLMIdentity)
+		}
+	},
+}
+
+func init() {
+	fuidCmd.AddCommand(fuidAddIpCmd)
+	addUserSelectorFlags(fuidAddIpCmd)
+	fuidAddIpCmd.Flags().StringSliceVarP(&fuidAddIpAddresses, "ip", "", nil, "IP address to add, can be repeated or comma separated")
+	if err := fuidAddIpCmd.MarkFlagRequired("ip"); err != nil {
+		logrus.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,30 @@
+// api-version command prints the FUID API version negotiated with FUID and its capabilities
+
+package cmd
+
+import (
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var fuidApiVersionCmd = &cobra.Command{
+	Use:   "api-version",
+	Short: "Print the FUID API version in use",
+	Long:  `Probe the FUID API and print the version used by the integration with its capabilities, as JSON`,
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := getFUIDController().Client()
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		printJSON(struct {
+			Version      string               `json:"version"`
+			Capabilities lib.FUIDCapabilities `json:"capabilities"`
+		}{client.Version(), client.Capabilities()})
+	},
+}
+
+func init() {
+	fuidCmd.AddCommand(fuidApiVersionCmd)
+}
@@ -0,0 +1,34 @@
+// delete-user command removes a user from the FUID database
+
+package cmd
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var fuidDeleteUserCmd = &cobra.Command{
+	Use:   "delete-user",
+	Short: "Delete a user from FUID",
+	Long:  `Delete a user selected by its NTLM identity (--ntlm-identity) or objectGUID (--guid) from the FUID database`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fuidController := getFUIDController()
+		user, err := lookupFUIDUser(fuidController)
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		if err := fuidController.DeleteUser(user.ObjectGUID); err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		if DisplayProcess {
+			logrus.Infof("user %s has been deleted from FUID", user.NTLMIdentity)
+		}
+	},
+}
+
+func init() {
+	fuidCmd.AddCommand(fuidDeleteUserCmd)
+	addUserSelectorFlags(fuidDeleteUserCmd)
+}
@@ -0,0 +1,61 @@
+// export command writes all the users of the FUID database to a JSON or CSV file
+
+package cmd
+
+import (
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var (
+	fuidExportFormat string
+	fuidExportOutput string
+)
+
+var fuidExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the FUID users",
+	Long:  `Export all the users of the FUID database in JSON or CSV format (--format) to a file (--output) or to stdout`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exportFUIDUsers(); err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+	},
+}
+
+// exportFUIDUsers write the FUID users to the output, the format is checked before the output file is replaced
+func exportFUIDUsers() error {
+	if err := lib.ValidateExportFormat(fuidExportFormat); err != nil {
+		return err
+	}
+	allUsers, err := getFUIDController().ListUsers()
+	if err != nil {
+		return err
+	}
+	var output io.Writer = os.Stdout
+	if fuidExportOutput != "" {
+		outputFile, err := os.OpenFile(fuidExportOutput, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		defer outputFile.Close()
+		output = outputFile
+	}
+	if err := lib.ExportUsers(allUsers.Users, fuidExportFormat, output); err != nil {
+		return err
+	}
+	if DisplayProcess && fuidExportOutput != "" {
+		logrus.Infof("exported %d users to %s", len(allUsers.Users), fuidExportOutput)
+	}
+	return nil
+}
+
+func init() {
+	fuidCmd.AddCommand(fuidExportCmd)
+	fuidExportCmd.Flags().StringVarP(&fuidExportFormat, "format", "f", lib.ExportFormatJSON, "export format: json or csv")
+	fuidExportCmd.Flags().StringVarP(&fuidExportOutput, "output", "o", "", "output file path, default is stdout")
+}
@@ -0,0 +1,27 @@
+// get-user command reads a user from the FUID database by its NTLM identity or objectGUID
+
+package cmd
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var fuidGetUserCmd = &cobra.Command{
+	Use:   "get-user",
+	Short: "Read a user from FUID",
+	Long:  `Read a user from the FUID database by its NTLM identity (--ntlm-identity) or objectGUID (--guid)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		user, err := lookupFUIDUser(getFUIDController())
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		printJSON(user)
+	},
+}
+
+func init() {
+	fuidCmd.AddCommand(fuidGetUserCmd)
+	addUserSelectorFlags(fuidGetUserCmd)
+}
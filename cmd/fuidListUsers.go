@@ -0,0 +1,39 @@
+// list-users command lists the users stored in the FUID database
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var fuidListUsersCmd = &cobra.Command{
+	Use:   "list-users",
+	Short: "List the users in FUID",
+	Long:  `List the users stored in the FUID database with their objectGUID and IP addresses`,
+	Run: func(cmd *cobra.Command, args []string) {
+		allUsers, err := getFUIDController().ListUsers()
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(writer, "NTLM IDENTITY\tOBJECT GUID\tIPV4 ADDRESSES\tIPV6 ADDRESSES")
+		for _, user := range allUsers.Users {
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", user.NTLMIdentity, user.ObjectGUID,
+				strings.Join(user.Ipv4Addresses, ","), strings.Join(user.Ipv6Addresses, ","))
+		}
+		_ = writer.Flush()
+		if DisplayProcess {
+			logrus.Infof("found %d users in FUID", len(allUsers.Users))
+		}
+	},
+}
+
+func init() {
+	fuidCmd.AddCommand(fuidListUsersCmd)
+}
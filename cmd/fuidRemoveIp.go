@@ -0,0 +1,41 @@
+// remove-ip command removes IP addresses from a user in the FUID database
+
+package cmd
+
+import (
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var fuidRemoveIpAddresses []string
+
+var fuidRemoveIpCmd = &cobra.Command{
+	Use:   "remove-ip",
+	Short: "Remove IP addresses from a FUID user",
+	Long:  `Remove one or more IP addresses (--ip) from a user selected by its NTLM identity (--ntlm-identity) or objectGUID (--guid)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fuidController := getFUIDController()
+		user, err := lookupFUIDUser(fuidController)
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		if err := fuidController.UpdateUserIPs(user.ObjectGUID, lib.ChangeTypeDelete, fuidRemoveIpAddresses); err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		if DisplayProcess {
+			logrus.Infof("removed IP addresses %v from user %s", fuidRemoveIpAddresses, user.NTLMIdentity)
+		}
+	},
+}
+
+func init() {
+	fuidCmd.AddCommand(fuidRemoveIpCmd)
+	addUserSelectorFlags(fuidRemoveIpCmd)
+	fuidRemoveIpCmd.Flags().StringSliceVarP(&fuidRemoveIpAddresses, "ip", "", nil, "IP address to remove, can be repeated or comma separated")
+	if err := fuidRemoveIpCmd.MarkFlagRequired("ip"); err != nil {
+		logrus.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,23 @@
+// ldap command groups the administration sub-commands for the AD lookups of the integration
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// ldapCmd represents the ldap command
+var ldapCmd = &cobra.Command{
+	Use:   "ldap",
+	Short: "AD lookups administration",
+	Long:  `Manage the AD lookups of the integration. sub-commands {cache-flush}`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+		os.Exit(0)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ldapCmd)
+}
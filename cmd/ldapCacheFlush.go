@@ -0,0 +1,40 @@
+// cache-flush command removes the AD lookups cached by the running consumer, through its local API
+
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"net/http"
+)
+
+var ldapCacheFlushCmd = &cobra.Command{
+	Use:   "cache-flush",
+	Short: "Flush the LDAP cache of the consumer",
+	Long: `Remove the AD lookups cached by the running consumer, found and not found accounts, so the next sessions
+search their accounts in AD again. the consumer must run with LOCAL_API_ENABLED`,
+	Run: func(cmd *cobra.Command, args []string) {
+		resp, err := lib.LocalAPIRequest(http.MethodPost, lib.LocalApiLdapCache)
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		defer resp.Body.Close()
+		var result map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		if resp.StatusCode != http.StatusOK {
+			logrus.Errorf("the local API failed to flush the LDAP cache, status_code: %d, %v", resp.StatusCode, result["error"])
+			logrus.Exit(1)
+		}
+		printJSON(result)
+	},
+}
+
+func init() {
+	ldapCmd.AddCommand(ldapCacheFlushCmd)
+}
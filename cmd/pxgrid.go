@@ -11,7 +11,7 @@ import (
 var pxgridCmd = &cobra.Command{
 	Use:   "pxgrid",
 	Short: "Cisco PxGrid service",
-	Long:  `Cisco PxGrid service. sub-commands {create-client, consumer}`,
+	Long:  `Cisco PxGrid service. sub-commands {create-client, consumer, anc}`,
 	Run: func(cmd *cobra.Command, args []string) {
 		_ = cmd.Help()
 		os.Exit(0)
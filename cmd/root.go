@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -37,6 +38,19 @@ func init() {
 	viper.SetDefault("FUID_API_USERNAME", "")
 	viper.SetDefault("FUID_API_PASSWORD", "")
 	viper.SetDefault("FUID_PORT", 5000)
+	viper.SetDefault("FUID_API_VERSION", "")
+	viper.SetDefault("FUID_COALESCING_ENABLED", false)
+	viper.SetDefault("SESSION_BATCH_SIZE", 500)
+	viper.SetDefault("FUID_CACHE_TTL", 60)
+	viper.SetDefault("FUID_CACHE_WARMUP", false)
+	viper.SetDefault("FUID_CACHE_MAX_USERS", 100000)
+	viper.SetDefault("FUID_AUTH_MODE", lib.FUIDAuthBasic)
+	viper.SetDefault("FUID_API_TOKEN", "")
+	viper.SetDefault("FUID_API_TOKEN_FILE", "")
+	viper.SetDefault("FUID_CLIENT_CERT_PATH", "")
+	viper.SetDefault("FUID_CLIENT_KEY_PATH", "")
+	viper.SetDefault("FUID_ENDPOINTS", "")
+	viper.SetDefault("FUID_ENDPOINT_DOWN_TIME", 60)
 	//AD configs
 	viper.SetDefault("AD_LDAP_HOST", "")
 	viper.SetDefault("AD_PORT", 636)
@@ -46,12 +60,109 @@ func init() {
 	viper.SetDefault("LDAP_TIMEOUT", 10)
 	viper.SetDefault("LDAP_PAGES", 500)
 	viper.SetDefault("LDAP_FILTER", "(&(sAMAccountName=%s))")
-	viper.SetDefault("LDAP_ATTRIBUTES", "memberOf,objectclass,objectGUID,sAMAccountName,userPrincipalName,CN")
+	viper.SetDefault("LDAP_ATTRIBUTES", "memberOf,objectclass,objectGUID,objectSid,sAMAccountName,userPrincipalName,CN")
+	viper.SetDefault("AD_NETBIOS_NAME", "")
+	viper.SetDefault("LDAP_IDENTITY_ATTRIBUTE", lib.LdapIdentityObjectGUID)
+	viper.SetDefault("LDAP_ATTRIBUTE_MAPPING", map[string]string{
+		lib.LdapFieldDn:     lib.LdapEntryDN,
+		lib.LdapFieldMail:   "mail",
+		lib.LdapFieldGroups: "memberOf",
+	})
+	viper.SetDefault("LDAP_DEAD_LETTER_PATH", "")
+	viper.SetDefault("LDAP_CACHE_TTL", 300)
+	viper.SetDefault("LDAP_CACHE_NEGATIVE_TTL", 60)
+	viper.SetDefault("LDAP_CACHE_MAX_ENTRIES", 100000)
+	//machine authentication configs
+	viper.SetDefault("MACHINE_AUTH_ENABLED", false)
+	viper.SetDefault("MACHINE_LDAP_FILTER", "(&(objectClass=computer)(sAMAccountName=%s))")
+	viper.SetDefault("IDENTITY_PRECEDENCE", lib.PrecedenceUser)
+	//HTTP clients configs, timeouts are in seconds
+	for _, target := range []string{lib.TargetISEControl, lib.TargetISESession, lib.TargetFUID, lib.TargetWebhook} {
+		viper.SetDefault(target+"_CONNECT_TIMEOUT", 5)
+		viper.SetDefault(target+"_READ_TIMEOUT", 5)
+		viper.SetDefault(target+"_TIMEOUT", 5)
+		viper.SetDefault(target+"_MAX_RETRIES", 3)
+		viper.SetDefault(target+"_RETRY_BACKOFF_MS", 500)
+		viper.SetDefault(target+"_RETRY_MAX_BACKOFF_MS", 10000)
+		viper.SetDefault(target+"_BREAKER_THRESHOLD", 5)
+		viper.SetDefault(target+"_BREAKER_COOLDOWN", 30)
+	}
+	viper.SetDefault("ISE_SESSION_READ_TIMEOUT", 30)
+	//rate limits, the rate is in requests per second, zero is unlimited
+	for _, limiter := range []string{lib.LimiterFUID, lib.LimiterLDAP, lib.LimiterISE} {
+		viper.SetDefault(limiter+"_RATE_LIMIT", 0)
+		viper.SetDefault(limiter+"_RATE_BURST", 0)
+		viper.SetDefault(limiter+"_MAX_IN_FLIGHT", 0)
+	}
+	viper.SetDefault("ISE_SESSION_TIMEOUT", 120)
 	//other Config
 	viper.SetDefault("SESSION_LISTENER_INTERVAL_TIME", 3)
 	viper.SetDefault("SAVE_LOGS", false)
 	viper.SetDefault("DISPLAY_INFO", false)
 	viper.SetDefault("IGNORE_UNKNOWN_SESSIONS", true)
+	viper.SetDefault("IDENTITY_SINKS", "fuid")
+	viper.SetDefault("IDENTITY_SINKS_REQUIRED", "fuid")
+	viper.SetDefault("MAPPING_INDEX_TTL", 24)
+	//local API configs
+	viper.SetDefault("LOCAL_API_ENABLED", false)
+	viper.SetDefault("LOCAL_API_ADDRESS", "127.0.0.1:9180")
+	viper.SetDefault("LOCAL_API_TOKEN", "")
+	viper.SetDefault("LOCAL_API_ANC_TOKEN", "")
+	viper.SetDefault("LOCAL_API_TLS_CERT_PATH", "")
+	viper.SetDefault("LOCAL_API_TLS_KEY_PATH", "")
+	viper.SetDefault("ANC_ENABLED", false)
+	viper.SetDefault("ISE_SERVICES_SOURCE", "")
+	viper.SetDefault("SESSION_RULES_DEFAULT_ACTION", lib.RuleActionInclude)
+	//HA configs
+	viper.SetDefault("HA_ENABLED", false)
+	viper.SetDefault("HA_LEASE_PATH", "")
+	viper.SetDefault("HA_LEASE_TIME", 30)
+	viper.SetDefault("HA_NODE_ID", "")
+	//posture configs
+	viper.SetDefault("POSTURED_SESSIONS_ENABLED", false)
+	viper.SetDefault("POSTURE_GROUPS_ENABLED", false)
+	viper.SetDefault("POSTURE_NON_COMPLIANT_GROUP", "CN=NonCompliant")
+	viper.SetDefault("POSTURE_JAILBROKEN_GROUP", "CN=JailBroken")
+	viper.SetDefault("POSTURE_UNENCRYPTED_GROUP", "")
+	//TrustSec configs
+	viper.SetDefault("SGT_GROUPS_ENABLED", false)
+	viper.SetDefault("SGT_GROUP_DN_PREFIX", "CN=SGT-")
+	viper.SetDefault("SGT_GROUP_DN_SUFFIX", "")
+	viper.SetDefault("TRUSTSEC_LOOKUP_ENABLED", false)
+	viper.SetDefault("TRUSTSEC_REFRESH_INTERVAL", 60)
+	//syslog sink configs
+	viper.SetDefault("SYSLOG_ADDRESS", "")
+	viper.SetDefault("SYSLOG_PROTOCOL", "udp")
+	viper.SetDefault("SYSLOG_FORMAT", "cef")
+	viper.SetDefault("SYSLOG_FACILITY", 16)
+	viper.SetDefault("SYSLOG_APP_NAME", "fuid-ise")
+	viper.SetDefault("SYSLOG_TIMEOUT", 5)
+	viper.SetDefault("SYSLOG_TLS_CA_PATH", "")
+	viper.SetDefault("SYSLOG_TLS_INSECURE_SKIP_VERIFY", false)
+	//webhook sink configs
+	viper.SetDefault("WEBHOOK_URL", "")
+	viper.SetDefault("WEBHOOK_TEMPLATE", "")
+	viper.SetDefault("WEBHOOK_TEMPLATE_PATH", "")
+	viper.SetDefault("WEBHOOK_HMAC_SECRET", "")
+	viper.SetDefault("WEBHOOK_SIGNATURE_HEADER", "X-Signature-256")
+	viper.SetDefault("WEBHOOK_BATCH_SIZE", 1)
+	viper.SetDefault("WEBHOOK_TLS_INSECURE_SKIP_VERIFY", false)
+	//kafka sink configs
+	viper.SetDefault("KAFKA_BROKERS", "")
+	viper.SetDefault("KAFKA_TOPIC", "")
+	viper.SetDefault("KAFKA_CLIENT_ID", "fuid-ise")
+	viper.SetDefault("KAFKA_VERSION", "1.0.0")
+	viper.SetDefault("KAFKA_MESSAGE_KEY", "user")
+	viper.SetDefault("KAFKA_MAX_RETRIES", 3)
+	viper.SetDefault("KAFKA_TIMEOUT", 10)
+	viper.SetDefault("KAFKA_TLS_ENABLED", false)
+	viper.SetDefault("KAFKA_TLS_CA_PATH", "")
+	viper.SetDefault("KAFKA_TLS_CERT_PATH", "")
+	viper.SetDefault("KAFKA_TLS_KEY_PATH", "")
+	viper.SetDefault("KAFKA_TLS_INSECURE_SKIP_VERIFY", false)
+	viper.SetDefault("KAFKA_SASL_MECHANISM", "")
+	viper.SetDefault("KAFKA_SASL_USERNAME", "")
+	viper.SetDefault("KAFKA_SASL_PASSWORD", "")
 
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "YAML config file ")
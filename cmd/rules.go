@@ -0,0 +1,23 @@
+// rules command groups the sub-commands of the session rules defined in SESSION_RULES
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// rulesCmd represents the rules command
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Session rules",
+	Long:  `Check the session rules of the config file. sub-commands {test}`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+		os.Exit(0)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rulesCmd)
+}
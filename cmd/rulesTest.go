@@ -0,0 +1,61 @@
+// test command evaluates the session rules for a sample ISE session read from a JSON file
+
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid/lib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	rulesTestSessionPath string
+	rulesTestSourceName  string
+)
+
+var rulesTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Evaluate the session rules for a sample session",
+	Long: `Evaluate the session rules for the session in the JSON file (--session), in the format of the ISE getSessions records.
+use - to read the session from the standard input. the rules of the ISE source --source are used. the decision is printed as JSON`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sources, err := lib.NewISESources()
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		source, err := lib.FindISESource(sources, rulesTestSourceName)
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		var sessionBytes []byte
+		if rulesTestSessionPath == "-" {
+			sessionBytes, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			sessionBytes, err = ioutil.ReadFile(rulesTestSessionPath)
+		}
+		if err != nil {
+			logrus.Error(err)
+			logrus.Exit(1)
+		}
+		var sess lib.Sessions
+		if err := json.Unmarshal(sessionBytes, &sess); err != nil {
+			logrus.Errorf("invalid session: %s", err)
+			logrus.Exit(1)
+		}
+		printJSON(source.RuleSet().Evaluate(&sess))
+	},
+}
+
+func init() {
+	rulesCmd.AddCommand(rulesTestCmd)
+	rulesTestCmd.Flags().StringVarP(&rulesTestSessionPath, "session", "", "", "path of the JSON session file, - for the standard input")
+	rulesTestCmd.Flags().StringVarP(&rulesTestSourceName, "source", "", "", "the name of the ISE source in ISE_SOURCES, default is the first source")
+	if err := rulesTestCmd.MarkFlagRequired("session"); err != nil {
+		logrus.Fatal(err.Error())
+	}
+}
@@ -3,12 +3,12 @@ module github.com/Forcepoint/fp-bd-fuid-cisco-pxgrid
 go 1.15
 
 require (
-	github.com/google/uuid v1.2.0
-	github.com/mitchellh/go-homedir v1.1.0 // indirect
+	github.com/Shopify/sarama v1.27.2
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.2.0
 	github.com/spf13/cobra v1.1.3
 	github.com/spf13/viper v1.7.0
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c
 	gopkg.in/asn1-ber.v1 v1.0.0-20181015200546-f715ec2f112d // indirect
 	//gopkg.in/asn1-ber.v1 v1.0.0-20181015200546-f715ec2f112d // indirect
 	gopkg.in/ldap.v2 v2.5.1
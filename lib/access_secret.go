@@ -18,7 +18,7 @@ type AccessSecretOutput struct {
 
 // AccessSecret return an access secret for a service provider
 func AccessSecret(peerNodeName string, controller *Controller) (*AccessSecretOutput, error) {
-	requestUrl := GetEndpointUrl(AccessSecretEndpoint)
+	requestUrl := controller.EndpointUrl(AccessSecretEndpoint)
 	input := AccessSecretInput{PeerNodeName: peerNodeName}
 	resp, err := controller.SendRequest(requestUrl, &input, http.MethodPost, true)
 	if err != nil {
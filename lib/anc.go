@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+)
+
+const (
+	ANCGetPoliciesEndpoint       = "getPolicies"
+	ANCApplyByMacAddressEndpoint = "applyEndpointByMacAddress"
+	ANCApplyByIpAddressEndpoint  = "applyEndpointByIpAddress"
+	ANCClearByMacAddressEndpoint = "clearEndpointByMacAddress"
+	ANCClearByIpAddressEndpoint  = "clearEndpointByIpAddress"
+	ANCStatusSuccess             = "SUCCESS"
+	ANCStatusFailure             = "FAILURE"
+	ANCStatusRunning             = "RUNNING"
+	LocalApiANCPolicies          = "/api/v1/anc/policies"
+	LocalApiANCApply             = "/api/v1/anc/apply"
+	LocalApiANCClear             = "/api/v1/anc/clear"
+)
+
+// ANCPolicy is an Adaptive Network Control policy defined in ISE
+type ANCPolicy struct {
+	Name    string   `json:"name"`
+	Actions []string `json:"actions,omitempty"`
+}
+
+type ANCPolicies struct {
+	Policies []ANCPolicy `json:"policies"`
+}
+
+// ANCEndpointInput select the endpoint of an apply or clear operation by its MAC or IP address
+type ANCEndpointInput struct {
+	PolicyName string `json:"policyName,omitempty"`
+	MacAddress string `json:"macAddress,omitempty"`
+	IpAddress  string `json:"ipAddress,omitempty"`
+}
+
+// ANCOperationStatus is the status of an apply or clear operation returned by ISE
+type ANCOperationStatus struct {
+	OperationId   string `json:"operationId,omitempty"`
+	MacAddress    string `json:"macAddress,omitempty"`
+	IpAddress     string `json:"ipAddress,omitempty"`
+	Status        string `json:"status,omitempty"`
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// ANCController apply and clear ANC policies through the pxGrid ANC service
+type ANCController struct {
+	service *ServiceClient
+}
+
+// NewANCController look up the ANC service and get the access secret of its provider node
+func NewANCController(controller *Controller) (*ANCController, error) {
+	service, err := NewServiceClient(ServiceLookupANC, controller)
+	if err != nil {
+		return nil, err
+	}
+	return &ANCController{service: service}, nil
+}
+
+// GetPolicies return the ANC policies defined in ISE
+func (a *ANCController) GetPolicies() (*ANCPolicies, error) {
+	var policies ANCPolicies
+	if err := a.service.Send(ANCGetPoliciesEndpoint, struct{}{}, true, &policies); err != nil {
+		return nil, err
+	}
+	return &policies, nil
+}
+
+// Apply apply a policy to the endpoint with the MAC address, or with the IP address when no MAC address is provided
+func (a *ANCController) Apply(input *ANCEndpointInput) (*ANCOperationStatus, error) {
+	if input.PolicyName == "" {
+		return nil, errors.New("the ANC policy name is not provided")
+	}
+	endpoint, err := ancEndpoint(input, ANCApplyByMacAddressEndpoint, ANCApplyByIpAddressEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return a.operation(endpoint, input)
+}
+
+// Clear clear the policy of the endpoint with the MAC address, or with the IP address when no MAC address is provided
+func (a *ANCController) Clear(input *ANCEndpointInput) (*ANCOperationStatus, error) {
+	endpoint, err := ancEndpoint(input, ANCClearByMacAddressEndpoint, ANCClearByIpAddressEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return a.operation(endpoint, input)
+}
+
+func (a *ANCController) operation(endpoint string, input *ANCEndpointInput) (*ANCOperationStatus, error) {
+	var status ANCOperationStatus
+	if err := a.service.Send(endpoint, input, false, &status); err != nil {
+		return nil, err
+	}
+	if status.Status == ANCStatusFailure {
+		return &status, errors.Errorf("ANC operation %s failed: %s", endpoint, status.FailureReason)
+	}
+	return &status, nil
+}
+
+// RegisterHandlers add the ANC endpoints to the local API, the apply and clear actions need the LOCAL_API_ANC_TOKEN
+func (a *ANCController) RegisterHandlers(api *LocalAPI) error {
+	api.HandleFunc(LocalApiANCPolicies, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		policies, err := a.GetPolicies()
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, policies)
+	})
+	if err := api.HandleANCFunc(LocalApiANCApply, http.MethodPost, a.handleOperation(a.Apply, true)); err != nil {
+		return err
+	}
+	return api.HandleANCFunc(LocalApiANCClear, http.MethodPost, a.handleOperation(a.Clear, false))
+}
+
+func (a *ANCController) handleOperation(operation func(*ANCEndpointInput) (*ANCOperationStatus, error), requirePolicy bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input ANCEndpointInput
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&input); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if requirePolicy && input.PolicyName == "" {
+			writeJSONError(w, http.StatusBadRequest, "policyName is required")
+			return
+		}
+		if _, err := ancEndpoint(&input, "", ""); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		status, err := operation(&input)
+		if err != nil {
+			if status != nil {
+				writeJSON(w, http.StatusBadGateway, status)
+				return
+			}
+			writeJSONError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// ancEndpoint validate the endpoint selector and return the MAC or IP address variant of an operation
+func ancEndpoint(input *ANCEndpointInput, byMacAddress, byIpAddress string) (string, error) {
+	if input.MacAddress != "" && input.IpAddress != "" {
+		return "", errors.New("only one of the MAC address or the IP address can be provided")
+	}
+	if input.MacAddress != "" {
+		input.MacAddress = normalizeMac(input.MacAddress)
+		if _, err := net.ParseMAC(input.MacAddress); err != nil {
+			return "", errors.Errorf("invalid MAC address %s", input.MacAddress)
+		}
+		return byMacAddress, nil
+	}
+	if input.IpAddress != "" {
+		if net.ParseIP(input.IpAddress) == nil {
+			return "", errors.Errorf("invalid IP address %s", input.IpAddress)
+		}
+		return byIpAddress, nil
+	}
+	return "", errors.New("one of the MAC address or the IP address is required")
+}
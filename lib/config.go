@@ -3,20 +3,25 @@ package lib
 import (
 	"crypto/tls"
 	"crypto/x509"
-	"github.com/spf13/viper"
 )
 
 type Config struct {
+	source *ISESource
 }
 
-// NewConfig create a new config file
-func NewConfig() *Config {
-	return &Config{}
+// NewConfig create the config of an ISE source
+func NewConfig(source *ISESource) *Config {
+	return &Config{source: source}
 }
 
 // GetTLSConfig generate TLS Config
 func (c *Config) GetTLSConfig() (*tls.Config, error) {
-	caCert, err := ExtractServerCert(viper.GetString("PXGRID_HOST_ADDRESS"), viper.GetInt("ISE_PORT"))
+	proxyConfig, err := c.GetProxyConfig()
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := ExtractServerCert(c.source.HostAddress, c.source.Port, proxyConfig,
+		NewHTTPClientConfig(TargetISEControl, proxyConfig).ConnectTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -30,3 +35,8 @@ func (c *Config) GetTLSConfig() (*tls.Config, error) {
 		InsecureSkipVerify: true,
 	}, nil
 }
+
+// GetProxyConfig read the egress proxy settings for ISE
+func (c *Config) GetProxyConfig() (*ProxyConfig, error) {
+	return NewProxyConfig(ProxyISE)
+}
@@ -1,23 +1,22 @@
 package lib
 
 import (
-	"fmt"
 	"github.com/pkg/errors"
-	"github.com/spf13/viper"
 )
 
 var (
 	NotFound error = errors.New("User Not Found in FUID Database")
+	Conflict error = errors.New("User change conflicts with FUID Database")
 )
 
 const (
-	RequestTimeoutValue           = 5 //the timeout in seconds for ISE requests
 	AccessLanguage                = "application/json"
 	ContentType                   = "application/json"
 	PxGridCreateClientEndPoint    = "pxgrid/control/AccountCreate"
 	PxGridAccountActivateEndPoint = "pxgrid/control/AccountActivate"
 	ServiceLookup                 = "pxgrid/control/ServiceLookup"
 	ServiceLookupSessions         = "com.cisco.ise.session"
+	ServiceLookupANC              = "com.cisco.ise.config.anc"
 	AccessSecretEndpoint          = "pxgrid/control/AccessSecret"
 	NoServiceAvailable            = "no service available"
 	Enabled                       = "ENABLED"
@@ -36,8 +35,3 @@ const (
 	//LDAP
 
 )
-
-func GetEndpointUrl(endpointName string) string {
-	return fmt.Sprintf("https://%s:%d/%s", viper.GetString("PXGRID_HOST_ADDRESS"),
-		viper.GetInt("ISE_PORT"), endpointName)
-}
@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -15,17 +15,6 @@ type ReadSessionInput struct {
 	StartTimestamp *time.Time `json:"startTimestamp"`
 }
 
-// ValidateUsernamePassword ensure the yaml config file contains ISE Credentials
-func ValidateUsernamePassword() error {
-	if viper.GetString("PXGRID_CLIENT_ACCOUNT_NAME") == "" {
-		return errors.New("Ise client username is not provided")
-	}
-	if viper.GetString("PXGRID_CLIENT_ACCOUNT_PASSWORD") == "" {
-		return errors.New("Ise client password is not provided")
-	}
-	return nil
-}
-
 // GetSessionRestUrl extract the Session REST API URL from a service
 func GetSessionRestUrl(services []Services) (string, string, error) {
 	for _, s := range services {
@@ -37,10 +26,25 @@ func GetSessionRestUrl(services []Services) (string, string, error) {
 
 }
 
-// SessionListener listen to session events
-func SessionListener(secret, restUrl, timeStampFilePath string, controller *Controller, fuidController *FUIDController, displayProcess bool) error {
+// GetServiceRestUrl extract the REST API URL and the node name of the first service providing one
+func GetServiceRestUrl(services []Services) (string, string, error) {
+	for _, s := range services {
+		restBaseUrl := s.Properties.RestBaseUrl
+		if restBaseUrl == "" {
+			restBaseUrl = s.Properties.RestBaseURL
+		}
+		if restBaseUrl != "" && s.NodeName != "" {
+			return restBaseUrl, s.NodeName, nil
+		}
+	}
+	return "", "", errors.New("cannot find any restBaseUrl in any service")
+}
+
+// SessionListener listen to session events of the controller ISE source, the poll is aborted when elector loses the HA leadership
+func SessionListener(secret, restUrl string, controller *Controller, sink IdentitySink, elector *LeaderElector, displayProcess bool) error {
+	source := controller.Source()
 	restUrl = fmt.Sprintf("%s/%s", restUrl, GetSessionEndpoint)
-	readSessionInput, err := GetLatestSessionTimeStamp(timeStampFilePath)
+	readSessionInput, err := GetLatestSessionTimeStamp(source.TimestampPath)
 	if err != nil {
 		return err
 	}
@@ -62,65 +66,201 @@ func SessionListener(secret, restUrl, timeStampFilePath string, controller *Cont
 		}
 		return errors.New(fmt.Sprintf("UnexpectedResponseError: status_code: %d, statusReason: %s", resp.StatusCode, resp.Status))
 	}
-	var sessions IseSessions
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err := json.Unmarshal(respBody, &sessions); err != nil {
-		if err := FixJson(respBody, &sessions); err != nil {
-			return errors.Wrap(err, "SessionListener")
-		}
-	}
-	if len(sessions.Sessions) != 0 {
-		if displayProcess {
-			logrus.Infof("Latest stored timestamp: %s", readSessionInput.StartTimestamp)
-			logrus.Infof("Number of new session events: %d", len(sessions.Sessions))
-		}
-		if err := ProcessSessions(&sessions, timeStampFilePath, fuidController, displayProcess); err != nil {
-			return err
-		}
+	defer resp.Body.Close()
+	if err := ProcessSessions(NewSessionDecoder(resp.Body), source, sink, elector, displayProcess); err != nil {
+		return errors.Wrap(err, "SessionListener")
 	}
 	return nil
 }
 
-// ProcessSessions process list of session events
-func ProcessSessions(sessions *IseSessions, timeStampFilePath string, fuidController *FUIDController, displayProcess bool) error {
+// ProcessSessions process the session events while they are decoded from the getSessions response and deliver them to the identity sink.
+// the sessions excluded by the source rules are not delivered. the leadership of elector is checked before the events
+// are delivered and before the timestamp is saved, a nil elector is always the leader
+func ProcessSessions(decoder *SessionDecoder, source *ISESource, sink IdentitySink, elector *LeaderElector, displayProcess bool) (err error) {
+	log := source.Log()
+	timeStampFilePath := source.TimestampPath
 	latestTimeStamp, err := readTimeStampFromDisk(timeStampFilePath)
 	if err != nil {
 		return err
 	}
 	maxTimeStamp := latestTimeStamp.StartTimestamp
-	for _, sess := range sessions.Sessions {
+	lostRecords := 0
+	delivery := newSessionDelivery(source.Name, sink, elector)
+	defer func() {
+		if err != nil {
+			// the buffered events are dropped, the timestamp is not saved so the sessions are delivered again in the next poll
+			delivery.reset()
+		}
+	}()
+	for {
+		sess, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if recordErr, ok := err.(*SessionRecordError); ok {
+				log.Errorf("session event is ignored: %s", recordErr.Error())
+				lostRecords++
+				continue
+			}
+			if streamErr, ok := err.(*SessionStreamError); ok {
+				log.Errorf("the rest of the getSessions response is ignored: %s", streamErr.Error())
+				lostRecords += streamErr.Lost
+				break
+			}
+			return err
+		}
+		if sess.Timestamp == nil {
+			log.Warningf("received a session event with no timestamp for user %s. this session event is ignored", sess.Username)
+			continue
+		}
 		if sess.Timestamp.After(*latestTimeStamp.StartTimestamp) && !sess.Timestamp.Equal(*latestTimeStamp.StartTimestamp) {
 			if sess.Timestamp.After(*maxTimeStamp) && !sess.Timestamp.Equal(*maxTimeStamp) {
 				maxTimeStamp = sess.Timestamp
 			}
-			if sess.State == AUTHENTICATED || sess.State == DISCONNECTED {
+			// the POSTURED sessions refresh the groups from AD, they are delivered when POSTURED_SESSIONS_ENABLED is set
+			postured := sess.State == POSTURED && viper.GetBool("POSTURED_SESSIONS_ENABLED")
+			if sess.State == AUTHENTICATED || sess.State == DISCONNECTED || postured {
+				decision := source.RuleSet().Evaluate(sess)
+				if !decision.Include {
+					if displayProcess {
+						log.Infof("the %s session of user %s is excluded by the session rules %s", sess.State, sess.Username, decision.Rule)
+					}
+					continue
+				}
 				//ignore unknown sessions
-				if sess.AdUserNetBiosName == "" && viper.GetBool("IGNORE_UNKNOWN_SESSIONS") {
-					logrus.Warnf("user %s is not a memeber of the Active Directory. the user's %s session is ignored", sess.State, sess.Username)
+				// the domain of a machine can come from AD_NETBIOS_NAME
+				unknownMachine := !isMachineSession(sess) || viper.GetString("AD_NETBIOS_NAME") == ""
+				if sess.AdUserNetBiosName == "" && unknownMachine && viper.GetBool("IGNORE_UNKNOWN_SESSIONS") {
+					log.Warnf("user %s is not a memeber of the Active Directory. the user's %s session is ignored", sess.State, sess.Username)
 					continue
 				}
 				// if session event has no ip address do no nothing
 				if sess.IpAddresses == nil || len(sess.IpAddresses) == 0 {
-					logrus.Warningf("received a session event with no ip-address for user %s. this session event is ignored", sess.AdUserSamAccountName)
+					log.Warningf("received a session event with no ip-address for user %s. this session event is ignored", sess.AdUserSamAccountName)
 					continue
 				}
-				if err := fuidController.UserManager(&sess, displayProcess); err != nil {
+				if err := delivery.add(&IdentityEvent{Session: sess, Source: source.Name, Tags: decision.Tags}); err != nil {
 					return err
 				}
 			}
 		}
 	}
+	if displayProcess && decoder.Decoded() != 0 {
+		log.Infof("Latest stored timestamp: %s", latestTimeStamp.StartTimestamp)
+		log.Infof("Number of new session events: %d", decoder.Decoded())
+	}
+	if lostRecords != 0 {
+		log.Warnf("%d session records could not be decoded and are lost", lostRecords)
+	}
+	if err := delivery.flush(); err != nil {
+		return err
+	}
 	if maxTimeStamp.After(*latestTimeStamp.StartTimestamp) && !maxTimeStamp.Equal(*latestTimeStamp.StartTimestamp) {
+		// the new leader polls from the shared checkpoint, it is not advanced past sessions it may not have seen
+		if !elector.IsLeader() {
+			return ErrLeadershipLost
+		}
 		if err := saveTimeStampToDisk(maxTimeStamp, timeStampFilePath); err != nil {
 			return err
 		}
 		if displayProcess {
-			logrus.Infof("New latest timestamp (%s) has been written to disk", maxTimeStamp.String())
+			log.Infof("New latest timestamp (%s) has been written to disk", maxTimeStamp.String())
+		}
+	}
+	return nil
+}
+
+// sessionDelivery deliver the events of a poll while the sessions are read. with a batch sink the events are
+// delivered in batches of SESSION_BATCH_SIZE events, so they can be coalesced, and the buffering sinks are flushed
+// after every batch, so the memory of a poll is bounded. without batch sink every event is delivered when it is read
+type sessionDelivery struct {
+	source    string
+	sink      IdentitySink
+	elector   *LeaderElector
+	size      int
+	batched   bool
+	events    []*IdentityEvent
+	delivered int
+}
+
+func newSessionDelivery(source string, sink IdentitySink, elector *LeaderElector) *sessionDelivery {
+	size := viper.GetInt("SESSION_BATCH_SIZE")
+	if size < 1 {
+		size = 1
+	}
+	return &sessionDelivery{source: source, sink: sink, elector: elector, size: size, batched: hasBatchSink(sink)}
+}
+
+// hasBatchSink report whether a sink delivers the events together
+func hasBatchSink(sink IdentitySink) bool {
+	if sinkSet, ok := sink.(*SinkSet); ok {
+		for _, s := range sinkSet.sinks {
+			if _, ok := s.(BatchSink); ok {
+				return true
+			}
 		}
+		return false
+	}
+	_, ok := sink.(BatchSink)
+	return ok
+}
+
+// add deliver an event, or keep it until its batch is full
+func (d *sessionDelivery) add(event *IdentityEvent) error {
+	d.events = append(d.events, event)
+	if d.batched && len(d.events) < d.size {
+		return nil
+	}
+	if err := d.deliver(); err != nil {
+		return err
+	}
+	if d.delivered >= d.size {
+		return d.flushSinks()
+	}
+	return nil
+}
+
+// deliver the kept events, unless the HA leadership was lost
+func (d *sessionDelivery) deliver() error {
+	if len(d.events) == 0 {
+		return nil
 	}
+	if !d.elector.IsLeader() {
+		return ErrLeadershipLost
+	}
+	if err := DispatchBatch(d.events, d.sink); err != nil {
+		return err
+	}
+	d.delivered += len(d.events)
+	d.events = nil
 	return nil
 }
 
+// flush deliver the rest of the events of the poll and flush the buffering sinks
+func (d *sessionDelivery) flush() error {
+	if err := d.deliver(); err != nil {
+		return err
+	}
+	return d.flushSinks()
+}
+
+func (d *sessionDelivery) flushSinks() error {
+	d.delivered = 0
+	if flusher, ok := d.sink.(SinkFlusher); ok {
+		return flusher.Flush(d.source)
+	}
+	return nil
+}
+
+// reset drop the events kept for an aborted poll
+func (d *sessionDelivery) reset() {
+	d.events = nil
+	if flusher, ok := d.sink.(SinkFlusher); ok {
+		flusher.Reset(d.source)
+	}
+}
+
 // saveTimeStampToDisk store th timestamp.
 func saveTimeStampToDisk(newTimestamp *time.Time, timeStampFilePath string) error {
 	newTimestampPlus := newTimestamp.Add(time.Millisecond)
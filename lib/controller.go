@@ -1,20 +1,28 @@
 package lib
 
 import (
-	"bytes"
-	"context"
 	"crypto/tls"
 	"encoding/json"
 	"github.com/pkg/errors"
-	"github.com/spf13/viper"
 	"net/http"
-	"time"
+	"strings"
 )
 
 type Controller struct {
-	config    *Config
-	client    *http.Client
-	tlsConfig *tls.Config
+	config        *Config
+	controlClient *HTTPClient
+	sessionClient *HTTPClient
+	tlsConfig     *tls.Config
+}
+
+// Source return the ISE source of the controller
+func (c *Controller) Source() *ISESource {
+	return c.config.source
+}
+
+// EndpointUrl return the URL of a pxGrid control endpoint of the controller ISE server
+func (c *Controller) EndpointUrl(endpointName string) string {
+	return c.config.source.EndpointUrl(endpointName)
 }
 
 // GetTlsConfig return the controller TLS config
@@ -28,13 +36,15 @@ func NewControl(config *Config) (*Controller, error) {
 	if err != nil {
 		return nil, err
 	}
-	transport := &http.Transport{
-		TLSClientConfig: tlsConfig,
+	proxyConfig, err := config.GetProxyConfig()
+	if err != nil {
+		return nil, err
 	}
 	control := &Controller{
-		config:    config,
-		client:    &http.Client{Transport: transport},
-		tlsConfig: tlsConfig,
+		config:        config,
+		controlClient: NewHTTPClient(NewHTTPClientConfig(TargetISEControl, proxyConfig), tlsConfig),
+		sessionClient: NewHTTPClient(NewHTTPClientConfig(TargetISESession, proxyConfig), tlsConfig),
+		tlsConfig:     tlsConfig,
 	}
 	return control, err
 }
@@ -45,56 +55,60 @@ func (c *Controller) SendRequest(url string, requestBody interface{}, requestMet
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeoutValue*time.Second)
-	defer cancel()
-	req, err := http.NewRequestWithContext(ctx, requestMethod, url, bytes.NewBuffer(requestBytes))
-	if err != nil {
-		return nil, err
+	// the pxGrid control calls only read or activate state, except the creation of a client account
+	request := &HTTPRequest{
+		Method:     requestMethod,
+		Url:        url,
+		Body:       requestBytes,
+		Idempotent: !strings.HasSuffix(url, PxGridCreateClientEndPoint),
 	}
-	req.Header.Set("Content-Type", ContentType)
-	req.Header.Set("Accept-Language", AccessLanguage)
 	if requireAuth {
-		if viper.GetString("PXGRID_CLIENT_ACCOUNT_NAME") == "" {
+		source := c.config.source
+		if source.ClientAccountName == "" {
 			return nil, errors.New("ISE client username is not provided")
 		}
-		if viper.GetString("PXGRID_CLIENT_ACCOUNT_PASSWORD") == "" {
+		if source.ClientAccountPassword == "" {
 			return nil, errors.New("ISE client password is not provided")
 
 		}
-		req.SetBasicAuth(viper.GetString("PXGRID_CLIENT_ACCOUNT_NAME"), viper.GetString("PXGRID_CLIENT_ACCOUNT_PASSWORD"))
-		return c.client.Do(req)
+		request.Username = source.ClientAccountName
+		request.Password = source.ClientAccountPassword
 	}
-	return c.client.Do(req)
+	return c.controlClient.Do(request)
 }
 
-// ReadSessions Read session events from PxGrid
+// ReadSessions Read session events from PxGrid, the response body is streamed and must be closed by the caller
 func (c *Controller) ReadSessions(secret, url string, requestBody interface{}) (*http.Response, error) {
-	var req *http.Request
-	var err error
-	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeoutValue*time.Second)
-	defer cancel()
+	request := &HTTPRequest{Method: http.MethodPost, Url: url, Idempotent: true, Stream: true}
 	if requestBody != nil {
 		requestBytes, err := json.Marshal(requestBody)
 		if err != nil {
 			return nil, err
 		}
+		request.Body = requestBytes
+	}
+	if c.config.source.ClientAccountName == "" {
+		return nil, errors.New("ISE client username is not provided")
+	}
+	request.Username = c.config.source.ClientAccountName
+	request.Password = secret
+	return c.sessionClient.Do(request)
+}
 
-		req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(requestBytes))
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+// ServiceRequest send a request to a pxGrid service provider with the access secret of the provider node
+func (c *Controller) ServiceRequest(secret, url string, requestBody interface{}, idempotent bool) (*http.Response, error) {
+	request := &HTTPRequest{Method: http.MethodPost, Url: url, Idempotent: idempotent}
+	if requestBody != nil {
+		requestBytes, err := json.Marshal(requestBody)
 		if err != nil {
 			return nil, err
 		}
+		request.Body = requestBytes
 	}
-	req.Header.Set("Content-Type", ContentType)
-	req.Header.Set("Accept-Language", AccessLanguage)
-	if viper.GetString("PXGRID_CLIENT_ACCOUNT_NAME") == "" {
+	if c.config.source.ClientAccountName == "" {
 		return nil, errors.New("ISE client username is not provided")
 	}
-	req.SetBasicAuth(viper.GetString("PXGRID_CLIENT_ACCOUNT_NAME"), secret)
-	return c.client.Do(req)
-
+	request.Username = c.config.source.ClientAccountName
+	request.Password = secret
+	return c.controlClient.Do(request)
 }
@@ -25,7 +25,7 @@ type AccountActivate struct {
 
 // Create create a ISE Client Account
 func (c *CreateClient) Create(controller *Controller) (*ISEClient, error) {
-	requestUrl := GetEndpointUrl(PxGridCreateClientEndPoint)
+	requestUrl := controller.EndpointUrl(PxGridCreateClientEndPoint)
 	resp, err := controller.SendRequest(requestUrl, c, http.MethodPost, false)
 	if err != nil {
 		return nil, err
@@ -54,7 +54,7 @@ func (c *CreateClient) Create(controller *Controller) (*ISEClient, error) {
 
 // AccountActivate Activate ISE Client Account
 func (c *CreateClient) AccountActivate(controller *Controller) (*AccountActivate, error) {
-	requestUrl := GetEndpointUrl(PxGridAccountActivateEndPoint)
+	requestUrl := controller.EndpointUrl(PxGridAccountActivateEndPoint)
 	resp, err := controller.SendRequest(requestUrl, c, http.MethodPost, true)
 	if err != nil {
 		return nil, err
@@ -1,33 +1,26 @@
 package lib
 
 import (
-	"bytes"
-	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"encoding/json"
-	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
-	"io/ioutil"
-	"net/http"
-	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 type FUIDUser struct {
-	Dn             string   `json:"dn,omitempty"`
-	ChangeType     string   `json:"changetype,omitempty"`
-	SAMAccountName string   `json:"sAMAccountName,omitempty"`
-	NTLMIdentity   string   `json:"NTLMIdentity,omitempty"`
-	Mail           string   `json:"mail,omitempty"`
-	Ipv4Addresses  []string `json:"ipv4_addresses,omitempty"`
-	Ipv6Addresses  []string `json:"ipv6_addresses,omitempty"`
-	ObjectGUID     string   `json:"objectGUID,omitempty"`
-	Groups         []string `json:"groups,omitempty"`
-	Timestamp      string   `json:"timestamp,omitempty"`
+	Dn                string   `json:"dn,omitempty"`
+	ChangeType        string   `json:"changetype,omitempty"`
+	SAMAccountName    string   `json:"sAMAccountName,omitempty"`
+	NTLMIdentity      string   `json:"NTLMIdentity,omitempty"`
+	Mail              string   `json:"mail,omitempty"`
+	UserPrincipalName string   `json:"userPrincipalName,omitempty"`
+	Ipv4Addresses     []string `json:"ipv4_addresses,omitempty"`
+	Ipv6Addresses     []string `json:"ipv6_addresses,omitempty"`
+	ObjectGUID        string   `json:"objectGUID,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	Timestamp         string   `json:"timestamp,omitempty"`
 }
 
 type AllUsers struct {
@@ -35,151 +28,200 @@ type AllUsers struct {
 }
 
 type FUIDController struct {
-	client *http.Client
+	transport      *fuidTransport
+	clientMutex    sync.Mutex
+	client         FUIDClient
+	cache          *FUIDUserCache
+	groupEnrichers []GroupEnricher
 }
 
-// GetTLSConfig Get TLS Config for FUID API
-func (f *FUIDController) GetTLSConfig() (*tls.Config, error) {
-	caCert, err := ExtractServerCert(viper.GetString("FUID_IP_ADDRESS"), viper.GetInt("FUID_PORT"))
+// GroupEnricher add the pseudo-groups derived from a session to the user groups
+type GroupEnricher interface {
+	// Merge return the groups with the pseudo-groups of the session, current are the groups of the user in FUID
+	Merge(groups, current []string, sess *Sessions) []string
+}
+
+// NewFUIDController Create a Controller for FUID API, the FUID API version is negotiated with FUID.
+// when FUID cannot be reached the version is negotiated at the first request.
+// at most FUID_CACHE_MAX_USERS users are cached for FUID_CACHE_TTL seconds, and read from the FUID users listing when
+// FUID_CACHE_WARMUP is set
+func NewFUIDController() (*FUIDController, error) {
+	controller := FUIDController{cache: NewFUIDUserCache(time.Duration(viper.GetInt("FUID_CACHE_TTL"))*time.Second, viper.GetInt("FUID_CACHE_MAX_USERS"))}
+	proxyConfig, err := NewProxyConfig(ProxyFUID)
 	if err != nil {
 		return nil, err
 	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-	tlsConfig := &tls.Config{
-		RootCAs:            caCertPool,
-		InsecureSkipVerify: true,
+	auth, err := NewFUIDAuthFromConfig()
+	if err != nil {
+		return nil, err
 	}
-	return tlsConfig, nil
-}
-
-// NewFUIDController Create a Controller for FUID API
-func NewFUIDController() (*FUIDController, error) {
-	controller := FUIDController{}
-	tlsConfig, err := controller.GetTLSConfig()
+	endpoints, err := NewFUIDEndpointPool(proxyConfig)
 	if err != nil {
 		return nil, err
 	}
-	transport := &http.Transport{
-		TLSClientConfig: tlsConfig,
+	controller.transport = &fuidTransport{endpoints: endpoints, auth: auth}
+	if _, err := controller.Client(); err != nil {
+		if !IsTransient(err) {
+			return nil, err
+		}
+		logrus.Warningf("cannot negotiate the FUID API version: %s", err)
+	} else if controller.cache.Enabled() && viper.GetBool("FUID_CACHE_WARMUP") {
+		controller.warmCache()
 	}
-	controller.client = &http.Client{Transport: transport}
 	return &controller, nil
 }
 
-// GetUser Search for a specific use in FUID Database
-func (f *FUIDController) GetUser(userNTLMIdentity string) (*FUIDUser, error) {
-	endpoint := fmt.Sprintf("%s/%s", UserNtlmIdentityEndpoint, userNTLMIdentity)
-	resp, err := f.SendRequest(endpoint, "", nil, http.MethodGet)
+// warmCache cache the users of the FUID users listing, the cache is filled by the sessions when FUID cannot list them
+func (f *FUIDController) warmCache() {
+	allUsers, err := f.ListUsers()
 	if err != nil {
-		return nil, err
+		logrus.Warningf("cannot warm the FUID user cache up: %s", err)
+		return
+	}
+	logrus.Infof("the FUID user cache is warmed up with %d users", f.cache.Warm(allUsers))
+}
+
+// Client return the client of the negotiated FUID API version
+func (f *FUIDController) Client() (FUIDClient, error) {
+	f.clientMutex.Lock()
+	defer f.clientMutex.Unlock()
+	if f.client == nil {
+		client, err := NegotiateFUIDClient(f.transport)
+		if err != nil {
+			return nil, err
+		}
+		if mapping, err := GetLdapAttributeMapping(); err == nil && mapping.Mapped(LdapFieldUPN) && !client.Capabilities().UserPrincipalName {
+			logrus.Warningf("the FUID API %s does not carry the userPrincipalName, the upn of LDAP_ATTRIBUTE_MAPPING is not sent", client.Version())
+		}
+		f.client = client
 	}
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, NotFound
+	return f.client, nil
+}
+
+// AddGroupEnricher add the pseudo-groups of an enricher to the user groups
+func (f *FUIDController) AddGroupEnricher(enricher GroupEnricher) {
+	f.groupEnrichers = append(f.groupEnrichers, enricher)
+}
+
+// mergeGroups apply the group enrichers to the groups of a user
+func (f *FUIDController) mergeGroups(groups, current []string, sess *Sessions) []string {
+	for _, enricher := range f.groupEnrichers {
+		groups = enricher.Merge(groups, current, sess)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("Failed in reading user %s from FUID with status error %d %s", userNTLMIdentity, resp.StatusCode, resp.Status)
+	return groups
+}
+
+// GetUser Search for a specific use in FUID Database, the cached user is returned when there is one
+func (f *FUIDController) GetUser(userNTLMIdentity string) (*FUIDUser, error) {
+	if user, ok := f.cache.Get(userNTLMIdentity); ok {
+		return user, nil
 	}
-	if resp == nil {
-		return nil, errors.Errorf("recived nil response for reading user %s from FUID with status error %d %s", userNTLMIdentity, resp.StatusCode, resp.Status)
+	client, err := f.Client()
+	if err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
-	responseBody, err := ioutil.ReadAll(resp.Body)
-	var user FUIDUser
-	if err := json.Unmarshal(responseBody, &user); err != nil {
+	user, err := client.GetUser(userNTLMIdentity)
+	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	f.cache.Put(userNTLMIdentity, user)
+	return user, nil
 }
 
-// SendRequest send a request to FUID API
-func (f *FUIDController) SendRequest(endPoint, parameters string, requestBody interface{}, requestMethod string) (*http.Response, error) {
-	var req *http.Request
-	var err error
-	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeoutValue*time.Second)
-	defer cancel()
-	requestUrl, err := generateUrl(endPoint, parameters)
+// GetUserByGUID Search for a user in FUID Database by its objectGUID
+func (f *FUIDController) GetUserByGUID(objectGUID string) (*FUIDUser, error) {
+	client, err := f.Client()
 	if err != nil {
 		return nil, err
 	}
-	urlParsed, err := url.Parse(requestUrl)
+	return client.GetUserByGUID(objectGUID)
+}
+
+// ListUsers read all users from FUID Database
+func (f *FUIDController) ListUsers() (*AllUsers, error) {
+	client, err := f.Client()
 	if err != nil {
 		return nil, err
 	}
-	requestUrl = urlParsed.String()
-	if requestBody != nil {
-		requestBytes, err := json.Marshal(requestBody)
-		if err != nil {
-			return nil, err
-		}
-		req, err = http.NewRequestWithContext(ctx, requestMethod, requestUrl, bytes.NewBuffer(requestBytes))
-		if err != nil {
-			return nil, err
-		}
+	return client.ListUsers()
+}
+
+// UpdateUserIPs add, modify or delete the IP addresses of a user in FUID Database
+func (f *FUIDController) UpdateUserIPs(objectGUID, changeType string, ipAddresses []string) error {
+	var newUser FUIDUser
+	newUser.ObjectGUID = objectGUID
+	newUser.ChangeType = changeType
+	newUser.Ipv4Addresses = ipAddresses
+	return f.updateUser(&newUser)
+}
+
+// DeleteUser remove a user from FUID Database
+func (f *FUIDController) DeleteUser(objectGUID string) error {
+	client, err := f.Client()
+	if err != nil {
+		return err
+	}
+	f.cache.InvalidateGUID(objectGUID)
+	return client.DeleteUser(objectGUID)
+}
+
+// updateUser send the change of a user to FUID Database and apply it to the cached user
+func (f *FUIDController) updateUser(user *FUIDUser) error {
+	return f.updateUsers([]*FUIDUser{user})
+}
+
+// updateUsers send the changes of several users to FUID Database, in one request when the FUID API version has the
+// BulkUpdate capability, the version 1.0 sends them one by one.
+// on failure the users are dropped from the cache, FUID may have applied a part of the changes or hold other ones
+func (f *FUIDController) updateUsers(users []*FUIDUser) error {
+	client, err := f.Client()
+	if err != nil {
+		return err
+	}
+	if len(users) == 1 {
+		err = client.UpdateUser(users[0])
 	} else {
-		req, err = http.NewRequestWithContext(ctx, requestMethod, requestUrl, nil)
-		if err != nil {
-			return nil, err
-		}
+		err = client.UpdateUsers(users)
 	}
-	req.Header.Set("Content-Type", ContentType)
-	req.Header.Set("Accept-Language", AccessLanguage)
-	if requestMethod != http.MethodGet {
-		if viper.GetString("FUID_API_USERNAME") != "" && viper.GetString("FUID_API_PASSWORD") != "" {
-			req.SetBasicAuth(viper.GetString("FUID_API_USERNAME"), viper.GetString("FUID_API_PASSWORD"))
+	for _, user := range users {
+		if err != nil {
+			f.cache.InvalidateGUID(user.ObjectGUID)
+		} else {
+			f.cache.Apply(user)
 		}
 	}
-	resp, err := f.client.Do(req)
-	if resp == nil {
-		return nil, err
-	}
-	return resp, err
+	return err
 }
 
-// UserManager manager a session, if your is not exists in FUID database, create it, otherwise update the user IP Addresses ang Groups
-func (f *FUIDController) UserManager(sess *Sessions, displayProcess bool) error {
-	userAccountName := sess.AdUserSamAccountName
-	if userAccountName == "" {
-		userAccountName = sess.AdNormalizedUser
-	}
-	if userAccountName == "" {
-		if sess.Username == "" {
-			return errors.Errorf("User %s is not avaiable in Active Directory, set IGNORE_UNKNOWN_SESSIONS in the config file to true to ingone unknown sessions", sess.Username)
-		}
-		usernameParts := strings.Split(sess.Username, "@")
-		userAccountName = usernameParts[0]
-	}
-	useNetBiosName := sess.AdUserNetBiosName
-	if useNetBiosName == "" {
-		return errors.Errorf("User %s is not avaiable in Active Directory, set IGNORE_UNKNOWN_SESSIONS in the config file to true to ingone unknown sessions", sess.Username)
+// UserManager manager a session, if your is not exists in FUID database, create it, otherwise update the user IP Addresses ang Groups.
+// the resolved FUID user, the AD object and the action taken are stored in the event
+func (f *FUIDController) UserManager(event *IdentityEvent, displayProcess bool) error {
+	sess := event.Session
+	account, err := sessionAccount(sess)
+	if err != nil {
+		return err
 	}
-	username := fmt.Sprintf("%s\\%s", useNetBiosName, userAccountName)
+	username := account.NTLMIdentity()
 	logrus.Info(username)
 	user, err := f.GetUser(username)
 	if err != nil {
 		if err == NotFound {
 			//connect to AD and read the user Object
-			logrus.Warningf("User '%s' is not exist in FUID Database", sess.AdUserSamAccountName)
-			ldapConnector, err := NewADConnector()
+			logrus.Warningf("User '%s' is not exist in FUID Database", account.SAMAccountName)
+			userEntity, err := readLdapUser(account, displayProcess)
 			if err != nil {
+				if skippedLdapLookup(account, err) {
+					return nil
+				}
 				return err
 			}
-			if displayProcess {
-				logrus.Infof("Connecting with AD Domain Conttroler %s", viper.GetString("AD_LDAP_HOST"))
-			}
-			defer ldapConnector.Close()
-			userEntity, err := GetLdapElement(sess.AdUserSamAccountName, ldapConnector)
+			event.LdapElement = userEntity
+			newUser, err := f.PostUser(userEntity, sess, displayProcess)
 			if err != nil {
 				return err
 			}
-			if displayProcess {
-				logrus.Infof("Read User Object from AD Domanin Controller for user %s", sess.AdUserSamAccountName)
-			}
-			err = f.PostUser(userEntity, sess, displayProcess)
-			if err != nil {
-				return err
-			}
-
+			event.User = newUser
+			event.Action = ActionCreated
 			return nil
 		} else {
 			return err
@@ -188,14 +230,146 @@ func (f *FUIDController) UserManager(sess *Sessions, displayProcess bool) error
 	if displayProcess {
 		logrus.Infof("Succefully read user object from FUID LDAP for user %s", user.NTLMIdentity)
 	}
-	if err := f.PutUser(user, sess, displayProcess); err != nil {
+	event.User = user
+	action, err := f.PutUser(user, sess, displayProcess)
+	if err != nil {
 		return err
 	}
+	event.Action = action
 	return nil
 }
 
-// PutUser Update a user's IP addresses and Groups
-func (f *FUIDController) PutUser(user *FUIDUser, sess *Sessions, displayProcess bool) error {
+// GroupManager refresh the groups of the session user from AD, a user which is not in FUID database is created
+func (f *FUIDController) GroupManager(event *IdentityEvent, displayProcess bool) error {
+	sess := event.Session
+	account, err := sessionAccount(sess)
+	if err != nil {
+		return err
+	}
+	user, err := f.GetUser(account.NTLMIdentity())
+	if err != nil {
+		if err == NotFound {
+			return f.UserManager(event, displayProcess)
+		}
+		return err
+	}
+	event.User = user
+	// the groups are refreshed from AD, the cached lookup is not used
+	userEntity, err := refreshLdapUser(account, displayProcess)
+	if err != nil {
+		if skippedLdapLookup(account, err) {
+			return nil
+		}
+		return err
+	}
+	event.LdapElement = userEntity
+	groups := f.mergeGroups(userEntity.Attributes.MemberOf, user.Groups, sess)
+	if sameGroups(user.Groups, groups) {
+		return nil
+	}
+	if err := f.UpdateUserGroups(user.ObjectGUID, groups); err != nil {
+		return err
+	}
+	user.Groups = groups
+	event.Action = ActionGroupsUpdated
+	if displayProcess {
+		logrus.Infof("updated the groups of user %s", user.NTLMIdentity)
+	}
+	return nil
+}
+
+// UpdateUserGroups replace the groups of a user in FUID Database
+func (f *FUIDController) UpdateUserGroups(objectGUID string, groups []string) error {
+	var newUser FUIDUser
+	newUser.ObjectGUID = objectGUID
+	newUser.ChangeType = ChangeTypeModify
+	newUser.Groups = groups
+	return f.updateUser(&newUser)
+}
+
+// readLdapUser read a user or computer object from AD, the cached lookup of the account is returned when there is one
+func readLdapUser(account *SessionAccount, displayProcess bool) (*LdapElement, error) {
+	if userEntity, ok, err := GetLdapCache().Get(account); ok {
+		if displayProcess {
+			logrus.Infof("Read User Object from the LDAP cache for user %s", account.SAMAccountName)
+		}
+		return userEntity, err
+	}
+	return refreshLdapUser(account, displayProcess)
+}
+
+// ldapAccountError report whether an AD lookup failed for the account only, the account is not in AD, matches
+// several objects or its entry cannot be read
+func ldapAccountError(err error) bool {
+	var lookupErr *LdapLookupError
+	var attributeErr *LdapAttributeError
+	return errors.As(err, &lookupErr) || errors.As(err, &attributeErr)
+}
+
+// skippedLdapLookup report whether an AD lookup failed for the account only, such a session is logged and skipped
+// so it does not fail the poll
+func skippedLdapLookup(account *SessionAccount, err error) bool {
+	if !ldapAccountError(err) {
+		return false
+	}
+	logrus.Warningf("the session of %s is skipped: %s", account.NTLMIdentity(), err)
+	return true
+}
+
+// refreshLdapUser search a user or computer object in AD within the LDAP limits, and cache the result
+func refreshLdapUser(account *SessionAccount, displayProcess bool) (*LdapElement, error) {
+	userEntity, err := searchLdapUser(account, displayProcess)
+	GetLdapCache().Put(account, userEntity, err)
+	return userEntity, err
+}
+
+// searchLdapUser search a user or computer object in AD, within the LDAP limits
+func searchLdapUser(account *SessionAccount, displayProcess bool) (*LdapElement, error) {
+	release := GetLimiter(LimiterLDAP).Acquire()
+	defer release()
+	ldapConnector, err := NewADConnector()
+	if err != nil {
+		return nil, err
+	}
+	if displayProcess {
+		logrus.Infof("Connecting with AD Domain Conttroler %s", viper.GetString("AD_LDAP_HOST"))
+	}
+	defer ldapConnector.Close()
+	userEntity, err := GetLdapElementByFilter(account.SAMAccountName, ldapAccountFilter(account), ldapConnector)
+	if err != nil {
+		return nil, err
+	}
+	if displayProcess {
+		logrus.Infof("Read User Object from AD Domanin Controller for user %s", account.SAMAccountName)
+	}
+	return userEntity, nil
+}
+
+// sameGroups report whether two group lists hold the same groups, the duplicates and the case are ignored
+func sameGroups(a, b []string) bool {
+	groupsA, groupsB := groupSet(a), groupSet(b)
+	if len(groupsA) != len(groupsB) {
+		return false
+	}
+	for group := range groupsA {
+		if !groupsB[group] {
+			return false
+		}
+	}
+	return true
+}
+
+// groupSet return the lower case groups of a list
+func groupSet(groups []string) map[string]bool {
+	set := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		set[strings.ToLower(group)] = true
+	}
+	return set
+}
+
+// PutUser Update a user's IP addresses and Groups, the change type sent to FUID is returned
+func (f *FUIDController) PutUser(user *FUIDUser, sess *Sessions, displayProcess bool) (string, error) {
 	switch sess.State {
 	case AUTHENTICATED:
 		if displayProcess {
@@ -209,21 +383,21 @@ func (f *FUIDController) PutUser(user *FUIDUser, sess *Sessions, displayProcess
 		newUser.ObjectGUID = user.ObjectGUID
 		newUser.ChangeType = changeType
 		newUser.Ipv4Addresses = sess.IpAddresses
-		endpoint := fmt.Sprintf("%s/%s", UserEndpoint, newUser.ObjectGUID)
-		resp, err := f.SendRequest(endpoint, "", &newUser, http.MethodPut)
-		if err != nil {
-			return err
+		// the pseudo-groups of the new session replace the previous ones
+		groups := f.mergeGroups(user.Groups, user.Groups, sess)
+		if !sameGroups(user.Groups, groups) {
+			newUser.Groups = groups
 		}
-		if resp.StatusCode == http.StatusUnauthorized {
-			return errors.New("Not Authorized to do Post request to FUID API")
+		if err := f.updateUser(&newUser); err != nil {
+			return "", err
 		}
-		if resp.StatusCode != http.StatusOK {
-			return errors.Errorf("add user to FUID statusCode %d %s", resp.StatusCode, resp.Status)
+		if newUser.Groups != nil {
+			user.Groups = groups
 		}
 		if displayProcess {
 			logrus.Infof("%s IP addresses for user %s", changeType, user.NTLMIdentity)
 		}
-		return nil
+		return changeType, nil
 
 	case DISCONNECTED:
 		if displayProcess {
@@ -233,69 +407,54 @@ func (f *FUIDController) PutUser(user *FUIDUser, sess *Sessions, displayProcess
 		newUser.ObjectGUID = user.ObjectGUID
 		newUser.ChangeType = ChangeTypeDelete
 		newUser.Ipv4Addresses = sess.IpAddresses
-		endpoint := fmt.Sprintf("%s/%s", UserEndpoint, newUser.ObjectGUID)
-		resp, err := f.SendRequest(endpoint, "", &newUser, http.MethodPut)
-		if err != nil {
-			return err
-		}
-		if resp.StatusCode == http.StatusUnauthorized {
-			return errors.New("Not Authorized to do Post request to FUID API")
-		}
-		if resp.StatusCode != http.StatusOK {
-			return errors.Errorf("add user to FUID statusCode %d %s", resp.StatusCode, resp.Status)
+		if err := f.updateUser(&newUser); err != nil {
+			return "", err
 		}
 		if displayProcess {
 			logrus.Infof("delete IP addresses for user  %s", user.NTLMIdentity)
 		}
-		return nil
+		return ChangeTypeDelete, nil
 	}
-	return nil
+	return "", nil
 
 }
 
-// PostUser Create a user in FUID Database.
-func (f *FUIDController) PostUser(userEntity *LdapElement, sess *Sessions, displayProcess bool) error {
+// PostUser Create a user in FUID Database, the created user is returned
+func (f *FUIDController) PostUser(userEntity *LdapElement, sess *Sessions, displayProcess bool) (*FUIDUser, error) {
+	account, err := sessionAccount(sess)
+	if err != nil {
+		return nil, err
+	}
 	var newUser FUIDUser
-	nTm := fmt.Sprintf("%s\\%s", sess.AdUserNetBiosName, sess.AdUserSamAccountName)
-	newUser.NTLMIdentity = nTm
-	newUser.Dn = sess.AdUserResolvedDns
+	newUser.NTLMIdentity = account.NTLMIdentity()
+	// the DN mapped from AD, else the DN resolved by ISE for the users, else the DN of the AD object
+	newUser.Dn = userEntity.Attributes.Dn
+	if newUser.Dn == "" && !account.Machine {
+		newUser.Dn = sess.AdUserResolvedDns
+	}
+	if newUser.Dn == "" {
+		newUser.Dn = userEntity.DN
+	}
+	newUser.Mail = userEntity.Attributes.Mail
 	newUser.Ipv4Addresses = sess.IpAddresses
-	newUser.SAMAccountName = sess.AdUserSamAccountName
+	newUser.SAMAccountName = account.SAMAccountName
 	newUser.ObjectGUID = userEntity.Attributes.ObjectGUID
-	newUser.Groups = userEntity.Attributes.MemberOf
-	endpoint := fmt.Sprintf("%s/%s", UserEndpoint, userEntity.Attributes.ObjectGUID)
-	resp, err := f.SendRequest(endpoint, "", &newUser, http.MethodPost)
+	newUser.Groups = f.mergeGroups(userEntity.Attributes.MemberOf, nil, sess)
+	client, err := f.Client()
 	if err != nil {
-		return err
-	}
-	if resp.StatusCode == http.StatusBadRequest {
-		defer resp.Body.Close()
-		d, _ := ioutil.ReadAll(resp.Body)
-		return errors.Errorf("error in posting user to FUID. %s", string(d))
+		return nil, err
 	}
-	if resp.StatusCode == http.StatusUnauthorized {
-		return errors.New("Not Authorized to do Post request to FUID API")
+	// the userPrincipalName is only sent to the FUID API versions which carry it, the version 1.0 does not
+	if client.Capabilities().UserPrincipalName {
+		newUser.UserPrincipalName = userEntity.Attributes.UserPrincipalName
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("add user to FUID statusCode %d %s", resp.StatusCode, resp.Status)
+	if err := client.CreateUser(&newUser); err != nil {
+		f.cache.Invalidate(newUser.NTLMIdentity)
+		return nil, err
 	}
+	f.cache.Put(newUser.NTLMIdentity, &newUser)
 	if displayProcess {
-		logrus.Infof("use %s has been written to FUILD Database", sess.AdUserSamAccountName)
-	}
-	return nil
-}
-
-// generateUrl Generate FUID endpoint URl.
-func generateUrl(endpoint, parameters string) (string, error) {
-	if viper.GetString("FUID_IP_ADDRESS") == "" {
-		return "", errors.New("The FUID API IP address is not provided")
-	}
-	if viper.GetInt("FUID_PORT") == 0 {
-		return "", errors.New("The FUID API port number is not provided")
-	}
-	generatedUrl := fmt.Sprintf("https://%s:%d/api/uid/v1.0/%s", viper.GetString("FUID_IP_ADDRESS"), viper.GetInt("FUID_PORT"), endpoint)
-	if parameters != "" {
-		generatedUrl = fmt.Sprintf("%s?%s", generatedUrl, parameters)
+		logrus.Infof("use %s has been written to FUILD Database", account.SAMAccountName)
 	}
-	return generatedUrl, nil
+	return &newUser, nil
 }
@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the authentication schemes of the FUID API requests
+const (
+	FUIDAuthBasic = "basic"
+	FUIDAuthToken = "token"
+	FUIDAuthNone  = "none"
+)
+
+// FUIDAuth authenticate every FUID API request, whatever its method.
+// the token of a token file is read again when the file changes, so it can be rotated without restart
+type FUIDAuth struct {
+	mutex        sync.Mutex
+	mode         string
+	username     string
+	password     string
+	token        string
+	tokenPath    string
+	tokenModTime time.Time
+}
+
+// NewFUIDAuthFromConfig create the authentication of the FUID_AUTH_MODE scheme:
+// basic uses FUID_API_USERNAME and FUID_API_PASSWORD, or no credentials when both are empty, token uses FUID_API_TOKEN or the content of FUID_API_TOKEN_FILE
+// and none sends no credentials, for a FUID authenticating the client certificate only
+func NewFUIDAuthFromConfig() (*FUIDAuth, error) {
+	auth := &FUIDAuth{mode: strings.ToLower(viper.GetString("FUID_AUTH_MODE"))}
+	switch auth.mode {
+	case "", FUIDAuthBasic:
+		auth.mode = FUIDAuthBasic
+		auth.username = viper.GetString("FUID_API_USERNAME")
+		auth.password = viper.GetString("FUID_API_PASSWORD")
+		if (auth.username == "") != (auth.password == "") {
+			return nil, errors.New("both FUID_API_USERNAME and FUID_API_PASSWORD are required for the FUID basic authentication")
+		}
+	case FUIDAuthToken:
+		auth.token = viper.GetString("FUID_API_TOKEN")
+		auth.tokenPath = viper.GetString("FUID_API_TOKEN_FILE")
+		if auth.token == "" && auth.tokenPath == "" {
+			return nil, errors.New("the FUID API token FUID_API_TOKEN or FUID_API_TOKEN_FILE is not provided")
+		}
+		if _, err := auth.bearerToken(); err != nil {
+			return nil, err
+		}
+	case FUIDAuthNone:
+	default:
+		return nil, errors.Errorf("unsupported FUID_AUTH_MODE '%s', supported modes are basic, token and none", auth.mode)
+	}
+	return auth, nil
+}
+
+// Apply add the credentials to a request
+func (a *FUIDAuth) Apply(request *HTTPRequest) error {
+	switch a.mode {
+	case FUIDAuthBasic:
+		if a.username != "" && a.password != "" {
+			request.Username = a.username
+			request.Password = a.password
+		}
+	case FUIDAuthToken:
+		token, err := a.bearerToken()
+		if err != nil {
+			return err
+		}
+		if request.Header == nil {
+			request.Header = http.Header{}
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// bearerToken return the configured token, or the content of the token file
+func (a *FUIDAuth) bearerToken() (string, error) {
+	if a.tokenPath == "" {
+		return a.token, nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	info, err := os.Stat(a.tokenPath)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot read the FUID API token file")
+	}
+	if a.token != "" && info.ModTime().Equal(a.tokenModTime) {
+		return a.token, nil
+	}
+	data, err := ioutil.ReadFile(a.tokenPath)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot read the FUID API token file")
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.Errorf("the FUID API token file %s is empty", a.tokenPath)
+	}
+	a.token = token
+	a.tokenModTime = info.ModTime()
+	return token, nil
+}
+
+// loadFUIDClientCertificates load the client certificate for mutual TLS with FUID, when FUID_CLIENT_CERT_PATH is set
+func loadFUIDClientCertificates() ([]tls.Certificate, error) {
+	certPath := viper.GetString("FUID_CLIENT_CERT_PATH")
+	keyPath := viper.GetString("FUID_CLIENT_KEY_PATH")
+	if certPath == "" && keyPath == "" {
+		return nil, nil
+	}
+	if certPath == "" || keyPath == "" {
+		return nil, errors.New("both FUID_CLIENT_CERT_PATH and FUID_CLIENT_KEY_PATH are required for the FUID client certificate")
+	}
+	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load the FUID client certificate")
+	}
+	return []tls.Certificate{certificate}, nil
+}
@@ -0,0 +1,208 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFUIDTransport create the transport of the FUID_* configs to a test FUID node
+func newTestFUIDTransport(t *testing.T, server *httptest.Server, config map[string]string) *fuidTransport {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("FUID_ENDPOINTS", strings.TrimPrefix(server.URL, "https://"))
+	for key, value := range config {
+		viper.Set(key, value)
+	}
+	proxyConfig, err := NewProxyConfig(ProxyFUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := NewFUIDAuthFromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints, err := NewFUIDEndpointPool(proxyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fuidTransport{endpoints: endpoints, auth: auth}
+}
+
+// sendTestRequest send a GET request to the test FUID node and return the status code
+func sendTestRequest(t *testing.T, transport *fuidTransport) int {
+	resp, err := transport.send("api/users", "", nil, http.MethodGet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestFUIDAuthBasic(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "fuid" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	transport := newTestFUIDTransport(t, server, map[string]string{
+		"FUID_AUTH_MODE":    FUIDAuthBasic,
+		"FUID_API_USERNAME": "fuid",
+		"FUID_API_PASSWORD": "secret",
+	})
+	if status := sendTestRequest(t, transport); status != http.StatusOK {
+		t.Errorf("a GET with basic auth got status %d", status)
+	}
+	transport = newTestFUIDTransport(t, server, map[string]string{"FUID_AUTH_MODE": FUIDAuthBasic})
+	if status := sendTestRequest(t, transport); status != http.StatusUnauthorized {
+		t.Errorf("a GET without credentials got status %d", status)
+	}
+}
+
+func TestFUIDAuthBasicPartialConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	for _, key := range []string{"FUID_API_USERNAME", "FUID_API_PASSWORD"} {
+		viper.Reset()
+		viper.Set("FUID_AUTH_MODE", FUIDAuthBasic)
+		viper.Set(key, "value")
+		if _, err := NewFUIDAuthFromConfig(); err == nil {
+			t.Errorf("the basic auth is accepted with %s only", key)
+		}
+	}
+}
+
+func TestFUIDAuthToken(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	transport := newTestFUIDTransport(t, server, map[string]string{"FUID_AUTH_MODE": FUIDAuthToken, "FUID_API_TOKEN": "token-1"})
+	if status := sendTestRequest(t, transport); status != http.StatusOK {
+		t.Errorf("a GET with the bearer token got status %d", status)
+	}
+	viper.Reset()
+	viper.Set("FUID_AUTH_MODE", FUIDAuthToken)
+	if _, err := NewFUIDAuthFromConfig(); err == nil {
+		t.Error("the token auth is accepted without token")
+	}
+}
+
+func TestFUIDAuthTokenFileRotation(t *testing.T) {
+	expected := "token-1"
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+expected {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "fuid-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenPath := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenPath, []byte("token-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	transport := newTestFUIDTransport(t, server, map[string]string{"FUID_AUTH_MODE": FUIDAuthToken, "FUID_API_TOKEN_FILE": tokenPath})
+	if status := sendTestRequest(t, transport); status != http.StatusOK {
+		t.Errorf("a GET with the token of the token file got status %d", status)
+	}
+	expected = "token-2"
+	if err := ioutil.WriteFile(tokenPath, []byte("token-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the modification time must change for the rotation to be seen, whatever the file system resolution
+	rotated := time.Now().Add(time.Minute)
+	if err := os.Chtimes(tokenPath, rotated, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if status := sendTestRequest(t, transport); status != http.StatusOK {
+		t.Errorf("a GET after the token rotation got status %d", status)
+	}
+}
+
+func TestFUIDAuthClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fuid-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certificate, certPath, keyPath := newTestClientCertificate(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(certificate)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	transport := newTestFUIDTransport(t, server, map[string]string{
+		"FUID_AUTH_MODE":        FUIDAuthNone,
+		"FUID_CLIENT_CERT_PATH": certPath,
+		"FUID_CLIENT_KEY_PATH":  keyPath,
+	})
+	if status := sendTestRequest(t, transport); status != http.StatusOK {
+		t.Errorf("a GET with the client certificate got status %d", status)
+	}
+	transport = newTestFUIDTransport(t, server, map[string]string{"FUID_AUTH_MODE": FUIDAuthNone})
+	if resp, err := transport.send("api/users", "", nil, http.MethodGet); err == nil {
+		resp.Body.Close()
+		t.Error("a GET without client certificate was accepted")
+	}
+}
+
+// newTestClientCertificate write a self-signed client certificate and its key in dir
+func newTestClientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fuid-ise"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certificate, certPath, keyPath
+}
@@ -0,0 +1,375 @@
+package lib
+
+import (
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+func init() {
+	DefaultMetrics.Describe("fuid_ise_fuid_coalesced_events_total", MetricCounter, "session events written to FUID by the coalescing")
+	DefaultMetrics.Describe("fuid_ise_fuid_saved_requests_total", MetricCounter, "FUID requests saved by the coalescing")
+}
+
+// FUIDBatchSink is the FUID sink coalescing the events of a poll cycle, enabled by FUID_COALESCING_ENABLED
+type FUIDBatchSink struct {
+	*FUIDSink
+}
+
+// NewFUIDBatchSink create a coalescing identity sink for FUID
+func NewFUIDBatchSink(controller *FUIDController, displayProcess bool) *FUIDBatchSink {
+	return &FUIDBatchSink{FUIDSink: NewFUIDSink(controller, displayProcess)}
+}
+
+// DeliverBatch write the net changes of the events to FUID
+func (f *FUIDBatchSink) DeliverBatch(events []*IdentityEvent) error {
+	return f.controller.BatchManager(events, f.displayProcess)
+}
+
+// FUIDBatchReport count the FUID requests of a poll cycle, an event written on its own takes a read and a write request
+type FUIDBatchReport struct {
+	Events   int
+	Users    int
+	Requests int
+}
+
+// Saved return the number of requests saved by the coalescing
+func (r *FUIDBatchReport) Saved() int {
+	if saved := 2*r.Events - r.Requests; saved > 0 {
+		return saved
+	}
+	return 0
+}
+
+// fuidUserChanges is the net change of a user over the events of a poll cycle
+type fuidUserChanges struct {
+	account *SessionAccount
+	events  []*IdentityEvent
+	// ipChanges hold the last change of every IP address, ChangeTypeAdd or ChangeTypeDelete
+	ipChanges map[string]string
+	ips       []string
+	// addedFirst hold the IP addresses whose first change in the poll cycle is an add
+	addedFirst map[string]bool
+	// groupSession is the last login or posture session, its pseudo-groups apply to the user
+	groupSession *Sessions
+	// refreshGroups is true when a posture event asks to read the groups from AD
+	refreshGroups bool
+
+	user          *FUIDUser
+	ldapElement   *LdapElement
+	created       bool
+	addChangeType string
+	writtenAdds   map[string]bool
+	writtenDels   map[string]bool
+	groupsWritten bool
+	failed        bool
+	// skipped is true when the account cannot be read from AD, its events are not written
+	skipped bool
+}
+
+// fuidUpdate is an update request of a user
+type fuidUpdate struct {
+	changes *fuidUserChanges
+	update  *FUIDUser
+}
+
+// coalesceEvents group the events per user, in the order of their first event
+func coalesceEvents(events []*IdentityEvent) ([]*fuidUserChanges, error) {
+	byUser := make(map[string]*fuidUserChanges)
+	var users []*fuidUserChanges
+	for _, event := range events {
+		account, err := sessionAccount(event.Session)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(account.NTLMIdentity())
+		changes, ok := byUser[key]
+		if !ok {
+			changes = &fuidUserChanges{
+				account:     account,
+				ipChanges:   make(map[string]string),
+				addedFirst:  make(map[string]bool),
+				writtenAdds: make(map[string]bool),
+				writtenDels: make(map[string]bool),
+			}
+			byUser[key] = changes
+			users = append(users, changes)
+		}
+		changes.add(event)
+	}
+	return users, nil
+}
+
+// add apply an event, a posture event holds an active session and its IP addresses are added like a login
+func (c *fuidUserChanges) add(event *IdentityEvent) {
+	c.events = append(c.events, event)
+	change := ChangeTypeAdd
+	switch event.Session.State {
+	case DISCONNECTED:
+		change = ChangeTypeDelete
+	case POSTURED:
+		c.refreshGroups = true
+		c.groupSession = event.Session
+	default:
+		c.groupSession = event.Session
+	}
+	for _, ip := range event.Session.IpAddresses {
+		if _, ok := c.ipChanges[ip]; !ok {
+			c.ips = append(c.ips, ip)
+			c.addedFirst[ip] = change == ChangeTypeAdd
+		}
+		c.ipChanges[ip] = change
+	}
+}
+
+// changed return the IP addresses whose last change is change
+func (c *fuidUserChanges) changed(change string) []string {
+	var ips []string
+	for _, ip := range c.ips {
+		if c.ipChanges[ip] == change {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// applied record an update written to FUID
+func (c *fuidUserChanges) applied(update *FUIDUser) {
+	if update.ChangeType == ChangeTypeDelete {
+		deleted := make(map[string]bool)
+		for _, ip := range update.Ipv4Addresses {
+			c.writtenDels[ip] = true
+			deleted[ip] = true
+		}
+		var ips []string
+		for _, ip := range c.user.Ipv4Addresses {
+			if !deleted[ip] {
+				ips = append(ips, ip)
+			}
+		}
+		c.user.Ipv4Addresses = ips
+	} else if len(update.Ipv4Addresses) != 0 {
+		for _, ip := range update.Ipv4Addresses {
+			c.writtenAdds[ip] = true
+		}
+		c.addChangeType = update.ChangeType
+		c.user.Ipv4Addresses = append(c.user.Ipv4Addresses, update.Ipv4Addresses...)
+	}
+	if update.Groups != nil {
+		c.user.Groups = update.Groups
+		c.groupsWritten = true
+	}
+}
+
+// complete store the user, the AD object and the action taken in the events of the user
+func (c *fuidUserChanges) complete() {
+	for _, event := range c.events {
+		event.User = c.user
+		event.LdapElement = c.ldapElement
+		event.Action = ActionCoalesced
+		switch {
+		case c.created:
+			if event.Session.State != DISCONNECTED {
+				event.Action = ActionCreated
+			}
+		case event.Session.State == DISCONNECTED:
+			if anyIP(event.Session.IpAddresses, c.writtenDels) {
+				event.Action = ActionIPDeleted
+			}
+		case anyIP(event.Session.IpAddresses, c.writtenAdds):
+			event.Action = c.addChangeType
+		case c.groupsWritten:
+			event.Action = ActionGroupsUpdated
+		}
+	}
+}
+
+// anyIP report whether one of ips is in set
+func anyIP(ips []string, set map[string]bool) bool {
+	for _, ip := range ips {
+		if set[ip] {
+			return true
+		}
+	}
+	return false
+}
+
+// BatchManager write the events of a poll cycle to FUID with one net change per user: the IP addresses added then
+// deleted, or already in the wanted state, are not written, and the users are read once. the updates are sent
+// one by one, the FUID API 1.0 has no bulk endpoint. the first error is returned once every user was tried
+func (f *FUIDController) BatchManager(events []*IdentityEvent, displayProcess bool) error {
+	if len(events) == 0 {
+		return nil
+	}
+	users, err := coalesceEvents(events)
+	if err != nil {
+		return err
+	}
+	report := &FUIDBatchReport{Events: len(events), Users: len(users)}
+	var firstErr error
+	fail := func(changes *fuidUserChanges, err error) {
+		changes.failed = true
+		if firstErr == nil {
+			firstErr = err
+		}
+		logrus.Errorf("cannot write the sessions of user %s to FUID: %s", changes.account.NTLMIdentity(), err)
+	}
+	var updates []*fuidUpdate
+	for _, changes := range users {
+		userUpdates, err := f.prepareUserChanges(changes, report, displayProcess)
+		if err != nil {
+			// FUID cannot be reached, the other users would fail as well
+			if IsTransient(err) {
+				return err
+			}
+			fail(changes, err)
+			continue
+		}
+		updates = append(updates, userUpdates...)
+	}
+	if err := f.sendUpdates(updates, report, fail); err != nil {
+		return err
+	}
+	for _, changes := range users {
+		if !changes.failed && !changes.skipped {
+			changes.complete()
+		}
+	}
+	DefaultMetrics.Add("fuid_ise_fuid_coalesced_events_total", nil, float64(report.Events))
+	DefaultMetrics.Add("fuid_ise_fuid_saved_requests_total", nil, float64(report.Saved()))
+	if report.Saved() != 0 || displayProcess {
+		logrus.Infof("FUID coalescing: %d session events of %d users written with %d requests, %d requests saved",
+			report.Events, report.Users, report.Requests, report.Saved())
+	}
+	return firstErr
+}
+
+// prepareUserChanges read a user from FUID and return the updates of its net change, a user which is not in FUID
+// database is created with the added IP addresses
+func (f *FUIDController) prepareUserChanges(changes *fuidUserChanges, report *FUIDBatchReport, displayProcess bool) ([]*fuidUpdate, error) {
+	account := changes.account
+	user, err := f.GetUser(account.NTLMIdentity())
+	report.Requests++
+	if err == NotFound {
+		adds := changes.changed(ChangeTypeAdd)
+		if len(adds) == 0 {
+			// the sessions of the user ended in the poll cycle, there is nothing to write
+			return nil, nil
+		}
+		logrus.Warningf("User '%s' is not exist in FUID Database", account.SAMAccountName)
+		userEntity, err := readLdapUser(account, displayProcess)
+		if err != nil {
+			if skippedLdapLookup(account, err) {
+				changes.skipped = true
+				return nil, nil
+			}
+			return nil, err
+		}
+		sess := *changes.groupSession
+		sess.IpAddresses = adds
+		newUser, err := f.PostUser(userEntity, &sess, displayProcess)
+		report.Requests++
+		if err != nil {
+			return nil, err
+		}
+		changes.user = newUser
+		changes.ldapElement = userEntity
+		changes.created = true
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	changes.user = user
+	current := make(map[string]bool, len(user.Ipv4Addresses))
+	for _, ip := range user.Ipv4Addresses {
+		current[ip] = true
+	}
+	// the deletes do not depend on the user read, which can come from the cache and miss the IP addresses added by
+	// other FUID clients. only the IP addresses added then removed in the poll cycle are not deleted
+	var adds, deletes []string
+	for _, ip := range changes.ips {
+		if changes.ipChanges[ip] == ChangeTypeAdd && !current[ip] {
+			adds = append(adds, ip)
+		}
+		if changes.ipChanges[ip] == ChangeTypeDelete && !changes.addedFirst[ip] {
+			deletes = append(deletes, ip)
+		}
+	}
+	var groups []string
+	if changes.groupSession != nil {
+		memberOf := user.Groups
+		if changes.refreshGroups {
+			// the IP addresses are written with the groups held in FUID when the account cannot be read from AD
+			userEntity, err := refreshLdapUser(account, displayProcess)
+			if err == nil {
+				changes.ldapElement = userEntity
+				memberOf = userEntity.Attributes.MemberOf
+			} else if ldapAccountError(err) {
+				logrus.Warningf("the groups of %s are not refreshed: %s", account.NTLMIdentity(), err)
+			} else {
+				return nil, err
+			}
+		}
+		// the pseudo-groups of the last session replace the previous ones
+		if merged := f.mergeGroups(memberOf, user.Groups, changes.groupSession); !sameGroups(user.Groups, merged) {
+			groups = merged
+		}
+	}
+	var updates []*fuidUpdate
+	if len(adds) != 0 {
+		changeType := ChangeTypeAdd
+		if len(user.Ipv4Addresses) != 0 {
+			changeType = ChangeTypeModify
+		}
+		updates = append(updates, &fuidUpdate{changes: changes, update: &FUIDUser{
+			ObjectGUID: user.ObjectGUID, ChangeType: changeType, Ipv4Addresses: adds, Groups: groups}})
+		groups = nil
+	}
+	if len(deletes) != 0 {
+		updates = append(updates, &fuidUpdate{changes: changes, update: &FUIDUser{
+			ObjectGUID: user.ObjectGUID, ChangeType: ChangeTypeDelete, Ipv4Addresses: deletes}})
+	}
+	if groups != nil {
+		updates = append(updates, &fuidUpdate{changes: changes, update: &FUIDUser{
+			ObjectGUID: user.ObjectGUID, ChangeType: ChangeTypeModify, Groups: groups}})
+	}
+	return updates, nil
+}
+
+// sendUpdates write the updates one by one. a FUID API version with the BulkUpdate capability would take them in one
+// request, the version 1.0 has none
+func (f *FUIDController) sendUpdates(updates []*fuidUpdate, report *FUIDBatchReport, fail func(*fuidUserChanges, error)) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	client, err := f.Client()
+	if err != nil {
+		return err
+	}
+	if client.Capabilities().BulkUpdate && len(updates) > 1 {
+		users := make([]*FUIDUser, 0, len(updates))
+		for _, update := range updates {
+			users = append(users, update.update)
+		}
+		report.Requests++
+		if err := f.updateUsers(users); err != nil {
+			return err
+		}
+		for _, update := range updates {
+			update.changes.applied(update.update)
+		}
+		return nil
+	}
+	for _, update := range updates {
+		report.Requests++
+		if err := f.updateUser(update.update); err != nil {
+			if IsTransient(err) {
+				return err
+			}
+			fail(update.changes, err)
+			continue
+		}
+		update.changes.applied(update.update)
+	}
+	return nil
+}
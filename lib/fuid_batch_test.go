@@ -0,0 +1,282 @@
+package lib
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFUIDClient is a FUID API 1.0 holding its users in memory, the create and update requests are recorded as
+// "changetype guid ips"
+type fakeFUIDClient struct {
+	users    map[string]*FUIDUser
+	requests []string
+}
+
+func newFakeFUIDClient(users ...*FUIDUser) *fakeFUIDClient {
+	client := &fakeFUIDClient{users: make(map[string]*FUIDUser)}
+	for _, user := range users {
+		client.users[strings.ToLower(user.NTLMIdentity)] = user
+	}
+	return client
+}
+
+func (c *fakeFUIDClient) Version() string {
+	return "1.0"
+}
+
+func (c *fakeFUIDClient) Capabilities() FUIDCapabilities {
+	return FUIDCapabilities{NTLMIdentityLookup: true, GroupUpdate: true}
+}
+
+func (c *fakeFUIDClient) Probe() (bool, error) {
+	return true, nil
+}
+
+func (c *fakeFUIDClient) GetUser(ntlmIdentity string) (*FUIDUser, error) {
+	user, ok := c.users[strings.ToLower(ntlmIdentity)]
+	if !ok {
+		return nil, NotFound
+	}
+	return copyFUIDUser(user), nil
+}
+
+func (c *fakeFUIDClient) GetUserByGUID(objectGUID string) (*FUIDUser, error) {
+	for _, user := range c.users {
+		if user.ObjectGUID == objectGUID {
+			return copyFUIDUser(user), nil
+		}
+	}
+	return nil, NotFound
+}
+
+func (c *fakeFUIDClient) ListUsers() (*AllUsers, error) {
+	users := &AllUsers{}
+	for _, user := range c.users {
+		users.Users = append(users.Users, *user)
+	}
+	return users, nil
+}
+
+func (c *fakeFUIDClient) CreateUser(user *FUIDUser) error {
+	c.requests = append(c.requests, fmt.Sprintf("create %s %s", user.ObjectGUID, strings.Join(user.Ipv4Addresses, ",")))
+	c.users[strings.ToLower(user.NTLMIdentity)] = copyFUIDUser(user)
+	return nil
+}
+
+func (c *fakeFUIDClient) UpdateUser(user *FUIDUser) error {
+	c.requests = append(c.requests, fmt.Sprintf("%s %s %s", user.ChangeType, user.ObjectGUID, strings.Join(user.Ipv4Addresses, ",")))
+	return nil
+}
+
+func (c *fakeFUIDClient) UpdateUsers(users []*FUIDUser) error {
+	for _, user := range users {
+		if err := c.UpdateUser(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *fakeFUIDClient) DeleteUser(objectGUID string) error {
+	return nil
+}
+
+// useTestLdapCache replace the LDAP cache of the process for a test
+func useTestLdapCache(t *testing.T, cache *LdapCache) {
+	previous := GetLdapCache()
+	ldapCache = cache
+	t.Cleanup(func() {
+		ldapCache = previous
+	})
+}
+
+func newTestSessionEvent(user, state string, ips ...string) *IdentityEvent {
+	return &IdentityEvent{Session: &Sessions{State: state, Username: user, IpAddresses: ips, AdUserNetBiosName: "EXAMPLE",
+		AdUserSamAccountName: user}}
+}
+
+func TestFUIDBatchManager(t *testing.T) {
+	tests := []struct {
+		name     string
+		users    []*FUIDUser
+		events   []*IdentityEvent
+		requests string
+		actions  string
+		saved    int
+	}{
+		{
+			name:  "re-authentication",
+			users: []*FUIDUser{{NTLMIdentity: "EXAMPLE\\jdoe", ObjectGUID: "guid-jdoe", Ipv4Addresses: []string{"10.0.0.1"}}},
+			events: []*IdentityEvent{
+				newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.1"),
+				newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.1"),
+			},
+			requests: "",
+			actions:  "coalesced,coalesced",
+			saved:    3,
+		},
+		{
+			name:  "roaming",
+			users: []*FUIDUser{{NTLMIdentity: "EXAMPLE\\jdoe", ObjectGUID: "guid-jdoe", Ipv4Addresses: []string{"10.0.0.1"}}},
+			events: []*IdentityEvent{
+				newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.2"),
+				newTestSessionEvent("jdoe", DISCONNECTED, "10.0.0.1"),
+			},
+			requests: "modify guid-jdoe 10.0.0.2|delete guid-jdoe 10.0.0.1",
+			actions:  "modify,delete",
+			saved:    1,
+		},
+		{
+			name:  "login and logout in one poll",
+			users: []*FUIDUser{{NTLMIdentity: "EXAMPLE\\jdoe", ObjectGUID: "guid-jdoe"}},
+			events: []*IdentityEvent{
+				newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.3"),
+				newTestSessionEvent("jdoe", DISCONNECTED, "10.0.0.3"),
+			},
+			requests: "",
+			actions:  "coalesced,coalesced",
+			saved:    3,
+		},
+		{
+			name:  "logout then login again",
+			users: []*FUIDUser{{NTLMIdentity: "EXAMPLE\\jdoe", ObjectGUID: "guid-jdoe", Ipv4Addresses: []string{"10.0.0.1"}}},
+			events: []*IdentityEvent{
+				newTestSessionEvent("jdoe", DISCONNECTED, "10.0.0.1"),
+				newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.1"),
+			},
+			requests: "",
+			actions:  "coalesced,coalesced",
+			saved:    3,
+		},
+		{
+			// the IP address is not in the user read, it can be held in FUID by another client
+			name:     "logout of an IP address missing from the user",
+			users:    []*FUIDUser{{NTLMIdentity: "EXAMPLE\\jdoe", ObjectGUID: "guid-jdoe"}},
+			events:   []*IdentityEvent{newTestSessionEvent("jdoe", DISCONNECTED, "10.0.0.9")},
+			requests: "delete guid-jdoe 10.0.0.9",
+			actions:  "delete",
+			saved:    0,
+		},
+		{
+			name: "two users in one poll",
+			users: []*FUIDUser{
+				{NTLMIdentity: "EXAMPLE\\jdoe", ObjectGUID: "guid-jdoe"},
+				{NTLMIdentity: "EXAMPLE\\asmith", ObjectGUID: "guid-asmith", Ipv4Addresses: []string{"10.0.0.5"}},
+			},
+			events: []*IdentityEvent{
+				newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.1"),
+				newTestSessionEvent("asmith", AUTHENTICATED, "10.0.0.6"),
+				newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.2"),
+			},
+			requests: "add guid-jdoe 10.0.0.1,10.0.0.2|modify guid-asmith 10.0.0.6",
+			actions:  "add,modify,add",
+			saved:    2,
+		},
+		{
+			name: "user created",
+			events: []*IdentityEvent{
+				newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.1"),
+				newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.2"),
+				newTestSessionEvent("jdoe", DISCONNECTED, "10.0.0.1"),
+			},
+			requests: "create guid-ldap 10.0.0.2",
+			actions:  "created,created,coalesced",
+			saved:    4,
+		},
+		{
+			name: "user not in FUID logged out",
+			events: []*IdentityEvent{
+				newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.1"),
+				newTestSessionEvent("jdoe", DISCONNECTED, "10.0.0.1"),
+			},
+			requests: "",
+			actions:  "coalesced,coalesced",
+			saved:    3,
+		},
+	}
+	cache := NewLdapCache(time.Hour, time.Hour, 0)
+	useTestLdapCache(t, cache)
+	cache.Put(&SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: "jdoe"},
+		&LdapElement{DN: "CN=jdoe,DC=example,DC=com", Attributes: Attributes{ObjectGUID: "guid-ldap", SAMAccountName: "jdoe"}}, nil)
+	for _, test := range tests {
+		client := newFakeFUIDClient(test.users...)
+		controller := &FUIDController{client: client, cache: NewFUIDUserCache(0, 0)}
+		saved := DefaultMetrics.Value("fuid_ise_fuid_saved_requests_total", nil)
+		if err := controller.BatchManager(test.events, false); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if requests := strings.Join(client.requests, "|"); requests != test.requests {
+			t.Errorf("%s: FUID got the requests %s instead of %s", test.name, requests, test.requests)
+		}
+		var actions []string
+		for _, event := range test.events {
+			actions = append(actions, event.Action)
+		}
+		if strings.Join(actions, ",") != test.actions {
+			t.Errorf("%s: the actions are %v instead of %s", test.name, actions, test.actions)
+		}
+		if saved := int(DefaultMetrics.Value("fuid_ise_fuid_saved_requests_total", nil) - saved); saved != test.saved {
+			t.Errorf("%s: %d requests saved instead of %d", test.name, saved, test.saved)
+		}
+	}
+}
+
+func TestFUIDBatchReportSaved(t *testing.T) {
+	tests := []struct {
+		report *FUIDBatchReport
+		saved  int
+	}{
+		{&FUIDBatchReport{Events: 3, Users: 1, Requests: 1}, 5},
+		{&FUIDBatchReport{Events: 1, Users: 1, Requests: 2}, 0},
+		// a user read then created and updated takes more requests than its event written on its own
+		{&FUIDBatchReport{Events: 1, Users: 1, Requests: 3}, 0},
+	}
+	for _, test := range tests {
+		if saved := test.report.Saved(); saved != test.saved {
+			t.Errorf("%+v: got %d instead of %d saved requests", test.report, saved, test.saved)
+		}
+	}
+}
+
+func TestFUIDBatchSkipsMalformedLdapEntries(t *testing.T) {
+	_, malformed := HandleElement(LdapEntity{DN: "CN=broken,DC=example,DC=com", Attributes: []KeyValue{
+		{"objectGUID": []string{"not a GUID"}},
+	}})
+	var attributeErr *LdapAttributeError
+	if !errors.As(malformed, &attributeErr) {
+		t.Fatalf("the malformed entry is read with %v", malformed)
+	}
+	cache := NewLdapCache(time.Hour, time.Hour, 0)
+	useTestLdapCache(t, cache)
+	cache.Put(&SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: "broken"}, nil, malformed)
+	cache.Put(&SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: "asmith"},
+		&LdapElement{DN: "CN=asmith,DC=example,DC=com", Attributes: Attributes{ObjectGUID: "guid-asmith"}}, nil)
+
+	// the sessions of the malformed entry are skipped, the other users of the batch are written
+	client := newFakeFUIDClient(&FUIDUser{NTLMIdentity: "EXAMPLE\\jdoe", ObjectGUID: "guid-jdoe"})
+	controller := &FUIDController{client: client, cache: NewFUIDUserCache(0, 0)}
+	events := []*IdentityEvent{
+		newTestSessionEvent("broken", AUTHENTICATED, "10.0.0.1"),
+		newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.2"),
+		newTestSessionEvent("asmith", AUTHENTICATED, "10.0.0.3"),
+	}
+	if err := controller.BatchManager(events, false); err != nil {
+		t.Fatalf("a malformed LDAP entry failed the batch: %s", err)
+	}
+	if requests := strings.Join(client.requests, "|"); requests != "create guid-asmith 10.0.0.3|add guid-jdoe 10.0.0.2" {
+		t.Errorf("FUID got the requests %s", requests)
+	}
+	if events[0].Action != "" || events[0].User != nil {
+		t.Errorf("the session of the malformed entry is written with the action '%s'", events[0].Action)
+	}
+
+	// the FUID sink skips the session as well
+	event := newTestSessionEvent("broken", AUTHENTICATED, "10.0.0.1")
+	if err := controller.UserManager(event, false); err != nil {
+		t.Errorf("a malformed LDAP entry failed the session: %s", err)
+	}
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	DefaultMetrics.Describe("fuid_ise_fuid_cache_hits_total", MetricCounter, "FUID user reads served by the user cache")
+	DefaultMetrics.Describe("fuid_ise_fuid_cache_misses_total", MetricCounter, "FUID user reads sent to FUID")
+	DefaultMetrics.Describe("fuid_ise_fuid_cache_users", MetricGauge, "users in the FUID user cache")
+}
+
+// FUIDUserCache keep the FUID users by NTLM identity, so a user updated by a session is not read again for its next sessions.
+// the cache is kept up to date by the writes of the integration, a user changed by another FUID client is read again
+// once its entry expired, or when FUID rejects a write with a not found or a conflict response
+type FUIDUserCache struct {
+	// mutex is held around the entries calls, so the guids are updated with them
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries *TTLCache
+	// guids map the objectGUID of the cached users to their NTLM identity key
+	guids map[string]string
+}
+
+// NewFUIDUserCache create a user cache of at most maxUsers users, a ttl of zero disables the cache and a maxUsers of zero
+// is unlimited
+func NewFUIDUserCache(ttl time.Duration, maxUsers int) *FUIDUserCache {
+	c := &FUIDUserCache{ttl: ttl, guids: make(map[string]string)}
+	c.entries = NewTTLCache(maxUsers, "fuid_ise_fuid_cache_users", func(key string, value interface{}) {
+		guid := strings.ToLower(value.(*FUIDUser).ObjectGUID)
+		if c.guids[guid] == key {
+			delete(c.guids, guid)
+		}
+	})
+	return c
+}
+
+// Enabled report whether the users are cached, a nil cache is disabled
+func (c *FUIDUserCache) Enabled() bool {
+	return c != nil && c.ttl > 0
+}
+
+// Get return a copy of a cached user, the hits and the misses are counted
+func (c *FUIDUserCache) Get(ntlmIdentity string) (*FUIDUser, bool) {
+	if !c.Enabled() {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	user, ok := c.entries.Get(strings.ToLower(ntlmIdentity))
+	if !ok {
+		DefaultMetrics.Add("fuid_ise_fuid_cache_misses_total", nil, 1)
+		return nil, false
+	}
+	DefaultMetrics.Add("fuid_ise_fuid_cache_hits_total", nil, 1)
+	return copyFUIDUser(user.(*FUIDUser)), true
+}
+
+// Put cache a copy of a user read from or written to FUID, the users without objectGUID are not cached
+func (c *FUIDUserCache) Put(ntlmIdentity string, user *FUIDUser) {
+	if !c.Enabled() || ntlmIdentity == "" || user.ObjectGUID == "" {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := strings.ToLower(ntlmIdentity)
+	if previous, ok := c.guids[strings.ToLower(user.ObjectGUID)]; ok && previous != key {
+		c.entries.Remove(previous)
+	}
+	c.entries.Put(key, copyFUIDUser(user), c.ttl)
+	c.guids[strings.ToLower(user.ObjectGUID)] = key
+}
+
+// Apply apply an update written to FUID to the cached user of its objectGUID, as FUID applies it:
+// add and modify add the IP addresses, delete removes them and the groups are replaced when they are set
+func (c *FUIDUserCache) Apply(update *FUIDUser) {
+	if !c.Enabled() {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key, ok := c.guids[strings.ToLower(update.ObjectGUID)]
+	if !ok {
+		return
+	}
+	cached, ok := c.entries.Get(key)
+	if !ok {
+		return
+	}
+	user := cached.(*FUIDUser)
+	if update.ChangeType == ChangeTypeDelete {
+		deleted := make(map[string]bool, len(update.Ipv4Addresses))
+		for _, ip := range update.Ipv4Addresses {
+			deleted[ip] = true
+		}
+		var ips []string
+		for _, ip := range user.Ipv4Addresses {
+			if !deleted[ip] {
+				ips = append(ips, ip)
+			}
+		}
+		user.Ipv4Addresses = ips
+	} else {
+		current := make(map[string]bool, len(user.Ipv4Addresses))
+		for _, ip := range user.Ipv4Addresses {
+			current[ip] = true
+		}
+		for _, ip := range update.Ipv4Addresses {
+			if !current[ip] {
+				user.Ipv4Addresses = append(user.Ipv4Addresses, ip)
+				current[ip] = true
+			}
+		}
+	}
+	if update.Groups != nil {
+		user.Groups = append([]string(nil), update.Groups...)
+	}
+}
+
+// Invalidate remove the cached user of a NTLM identity
+func (c *FUIDUserCache) Invalidate(ntlmIdentity string) {
+	if !c.Enabled() {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries.Remove(strings.ToLower(ntlmIdentity))
+}
+
+// InvalidateGUID remove the cached user of an objectGUID
+func (c *FUIDUserCache) InvalidateGUID(objectGUID string) {
+	if !c.Enabled() {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if key, ok := c.guids[strings.ToLower(objectGUID)]; ok {
+		c.entries.Remove(key)
+	}
+}
+
+// Warm cache the users of the FUID users listing, the number of cached users is returned
+func (c *FUIDUserCache) Warm(users *AllUsers) int {
+	if !c.Enabled() {
+		return 0
+	}
+	count := 0
+	for i := range users.Users {
+		user := &users.Users[i]
+		if user.NTLMIdentity == "" || user.ObjectGUID == "" {
+			continue
+		}
+		c.Put(user.NTLMIdentity, user)
+		count++
+	}
+	return count
+}
+
+// copyFUIDUser copy a user with its IP addresses and groups, so the cached users are not changed by their readers
+func copyFUIDUser(user *FUIDUser) *FUIDUser {
+	userCopy := *user
+	userCopy.Ipv4Addresses = append([]string(nil), user.Ipv4Addresses...)
+	userCopy.Ipv6Addresses = append([]string(nil), user.Ipv6Addresses...)
+	userCopy.Groups = append([]string(nil), user.Groups...)
+	return &userCopy
+}
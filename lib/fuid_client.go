@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net/http"
+	"strings"
+)
+
+// FUIDCapabilities are the optional features of a FUID API version
+type FUIDCapabilities struct {
+	// NTLMIdentityLookup is true when a user can be read by its NTLM identity
+	NTLMIdentityLookup bool `json:"ntlmIdentityLookup"`
+	// GroupUpdate is true when the groups of a user can be replaced
+	GroupUpdate bool `json:"groupUpdate"`
+	// BulkUpdate is true when the updates of several users are sent in one request
+	BulkUpdate bool `json:"bulkUpdate"`
+	// UserPrincipalName is true when the users carry their userPrincipalName, otherwise it is not sent
+	UserPrincipalName bool `json:"userPrincipalName"`
+}
+
+// FUIDClient is a version of the FUID API. it maps FUIDUser, AllUsers and the change types
+// to the paths and the request and response formats of its version
+type FUIDClient interface {
+	// Version return the API version, as in the API paths
+	Version() string
+	Capabilities() FUIDCapabilities
+	// Probe report whether the FUID node serves the version
+	Probe() (bool, error)
+	GetUser(ntlmIdentity string) (*FUIDUser, error)
+	GetUserByGUID(objectGUID string) (*FUIDUser, error)
+	ListUsers() (*AllUsers, error)
+	// CreateUser write a new user
+	CreateUser(user *FUIDUser) error
+	// UpdateUser apply the change type of user to its IP addresses, and replace its groups when they are set
+	UpdateUser(user *FUIDUser) error
+	// UpdateUsers apply the updates of several users, in one request when the version has the BulkUpdate capability
+	UpdateUsers(users []*FUIDUser) error
+	DeleteUser(objectGUID string) error
+}
+
+// fuidClientVersions create the clients of the supported FUID API versions, the newest first
+var fuidClientVersions = []func(transport *fuidTransport) FUIDClient{
+	newFUIDClientV1,
+}
+
+// FUIDCompatibilityError is returned when the FUID API serves none of the versions supported by the integration
+type FUIDCompatibilityError struct {
+	Supported []string
+	Requested string
+}
+
+func (e *FUIDCompatibilityError) Error() string {
+	if e.Requested != "" {
+		return fmt.Sprintf("the FUID API version %s is not supported by FUID or by the integration, the integration supports the versions %s",
+			e.Requested, strings.Join(e.Supported, ", "))
+	}
+	return fmt.Sprintf("the FUID API does not serve any version supported by the integration (%s), check the compatibility of the FUID version",
+		strings.Join(e.Supported, ", "))
+}
+
+// NegotiateFUIDClient probe the FUID API and return the client of the newest version it serves,
+// or of FUID_API_VERSION when it is set
+func NegotiateFUIDClient(transport *fuidTransport) (FUIDClient, error) {
+	requested := viper.GetString("FUID_API_VERSION")
+	var supported []string
+	for _, newClient := range fuidClientVersions {
+		client := newClient(transport)
+		supported = append(supported, client.Version())
+		if requested != "" && requested != client.Version() {
+			continue
+		}
+		ok, err := client.Probe()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			logrus.Infof("using FUID API version %s", client.Version())
+			return client, nil
+		}
+		logrus.Warningf("FUID API does not serve version %s", client.Version())
+	}
+	return nil, &FUIDCompatibilityError{Supported: supported, Requested: requested}
+}
+
+// fuidTransport send the authenticated FUID requests to the FUID nodes
+type fuidTransport struct {
+	endpoints *FUIDEndpointPool
+	auth      *FUIDAuth
+}
+
+// send a request to FUID API, requests other than POST are retried on failure and failed over to the other FUID nodes
+func (t *fuidTransport) send(path, parameters string, requestBody interface{}, requestMethod string) (*http.Response, error) {
+	request := &HTTPRequest{
+		Method:     requestMethod,
+		Idempotent: requestMethod != http.MethodPost,
+	}
+	if requestBody != nil {
+		requestBytes, err := json.Marshal(requestBody)
+		if err != nil {
+			return nil, err
+		}
+		request.Body = requestBytes
+	}
+	if err := t.auth.Apply(request); err != nil {
+		return nil, err
+	}
+	return t.endpoints.Do(path, parameters, request)
+}
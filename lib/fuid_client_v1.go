@@ -0,0 +1,210 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+)
+
+const FUIDAPIVersion1 = "v1.0"
+
+// fuidProbeIdentityV1 is the NTLM identity read by the version 1.0 probe, it is not a valid AD account
+const fuidProbeIdentityV1 = "FUID-ISE-PROBE\\version-probe"
+
+// fuidChangeTypesV1 are the change types of the version 1.0 user updates
+var fuidChangeTypesV1 = map[string]string{
+	ChangeTypeAdd:    "add",
+	ChangeTypeModify: "modify",
+	ChangeTypeDelete: "delete",
+}
+
+// fuidClientV1 is the FUID API version 1.0, its user format is FUIDUser without userPrincipalName
+type fuidClientV1 struct {
+	transport *fuidTransport
+}
+
+func newFUIDClientV1(transport *fuidTransport) FUIDClient {
+	return &fuidClientV1{transport: transport}
+}
+
+func (c *fuidClientV1) Version() string {
+	return FUIDAPIVersion1
+}
+
+func (c *fuidClientV1) Capabilities() FUIDCapabilities {
+	return FUIDCapabilities{NTLMIdentityLookup: true, GroupUpdate: true}
+}
+
+// send a request to a version 1.0 endpoint
+func (c *fuidClientV1) send(endpoint string, requestBody interface{}, requestMethod string) (*http.Response, error) {
+	return c.transport.send(fmt.Sprintf("api/uid/%s/%s", FUIDAPIVersion1, endpoint), "", requestBody, requestMethod)
+}
+
+// Probe read a user which does not exist, a FUID serving the version answers with a not found response
+func (c *fuidClientV1) Probe() (bool, error) {
+	resp, err := c.send(fmt.Sprintf("%s/%s", UserNtlmIdentityEndpoint, fuidProbeIdentityV1), nil, http.MethodGet)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
+		return true, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, errors.Errorf("Not Authorized to read the users from FUID API, status_code: %d", resp.StatusCode)
+	case http.StatusMethodNotAllowed, http.StatusBadRequest, http.StatusNotImplemented:
+		return false, nil
+	}
+	return false, errors.Errorf("unexpected response to the FUID API version %s probe, status_code: %d %s", FUIDAPIVersion1, resp.StatusCode, resp.Status)
+}
+
+// GetUser Search for a specific use in FUID Database
+func (c *fuidClientV1) GetUser(userNTLMIdentity string) (*FUIDUser, error) {
+	endpoint := fmt.Sprintf("%s/%s", UserNtlmIdentityEndpoint, userNTLMIdentity)
+	resp, err := c.send(endpoint, nil, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, NotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Failed in reading user %s from FUID with status error %d %s", userNTLMIdentity, resp.StatusCode, resp.Status)
+	}
+	var user FUIDUser
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserByGUID Search for a user in FUID Database by its objectGUID
+func (c *fuidClientV1) GetUserByGUID(objectGUID string) (*FUIDUser, error) {
+	endpoint := fmt.Sprintf("%s/%s", UserEndpoint, objectGUID)
+	resp, err := c.send(endpoint, nil, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, NotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Failed in reading user %s from FUID with status error %d %s", objectGUID, resp.StatusCode, resp.Status)
+	}
+	var user FUIDUser
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListUsers read all users from FUID Database
+func (c *fuidClientV1) ListUsers() (*AllUsers, error) {
+	resp, err := c.send(FuidAllUsers, nil, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Failed in reading users from FUID with status error %d %s", resp.StatusCode, resp.Status)
+	}
+	var allUsers AllUsers
+	if err := json.NewDecoder(resp.Body).Decode(&allUsers); err != nil {
+		return nil, err
+	}
+	return &allUsers, nil
+}
+
+// CreateUser post a user to FUID Database
+func (c *fuidClientV1) CreateUser(user *FUIDUser) error {
+	endpoint := fmt.Sprintf("%s/%s", UserEndpoint, user.ObjectGUID)
+	resp, err := c.send(endpoint, userV1(user), http.MethodPost)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusBadRequest {
+		d, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("error in posting user to FUID. %s", string(d))
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errors.New("Not Authorized to do Post request to FUID API")
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return Conflict
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("add user to FUID statusCode %d %s", resp.StatusCode, resp.Status)
+	}
+	return nil
+}
+
+// UpdateUser put the change of a user to FUID Database
+func (c *fuidClientV1) UpdateUser(user *FUIDUser) error {
+	changeType, ok := fuidChangeTypesV1[user.ChangeType]
+	if user.ChangeType != "" && !ok {
+		return errors.Errorf("the change type %s is not supported by the FUID API version %s", user.ChangeType, FUIDAPIVersion1)
+	}
+	update := userV1(user)
+	update.ChangeType = changeType
+	endpoint := fmt.Sprintf("%s/%s", UserEndpoint, user.ObjectGUID)
+	resp, err := c.send(endpoint, update, http.MethodPut)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errors.New("Not Authorized to do Put request to FUID API")
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return NotFound
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return Conflict
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s user %s in FUID statusCode %d %s", user.ChangeType, user.ObjectGUID, resp.StatusCode, resp.Status)
+	}
+	return nil
+}
+
+// UpdateUsers put the changes of the users one by one, the version 1.0 has no bulk endpoint
+func (c *fuidClientV1) UpdateUsers(users []*FUIDUser) error {
+	for _, user := range users {
+		if err := c.UpdateUser(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUser remove a user from FUID Database
+func (c *fuidClientV1) DeleteUser(objectGUID string) error {
+	endpoint := fmt.Sprintf("%s/%s", UserEndpoint, objectGUID)
+	resp, err := c.send(endpoint, nil, http.MethodDelete)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errors.New("Not Authorized to do Delete request to FUID API")
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return NotFound
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return errors.Errorf("delete user from FUID statusCode %d %s", resp.StatusCode, resp.Status)
+	}
+	return nil
+}
+
+// userV1 return the version 1.0 format of a user, the userPrincipalName is not part of it
+func userV1(user *FUIDUser) *FUIDUser {
+	userCopy := *user
+	userCopy.UserPrincipalName = ""
+	return &userCopy
+}
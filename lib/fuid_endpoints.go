@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FUIDEndpoint is a node of the FUID cluster, each node has its own HTTP client and circuit breaker
+type FUIDEndpoint struct {
+	Host      string
+	Port      int
+	client    *HTTPClient
+	downUntil time.Time
+}
+
+// Address return the host:port of the node
+func (e *FUIDEndpoint) Address() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// Url return the URL of a FUID API path on the node
+func (e *FUIDEndpoint) Url(path, parameters string) string {
+	generatedUrl := fmt.Sprintf("https://%s/%s", e.Address(), path)
+	if parameters != "" {
+		generatedUrl = fmt.Sprintf("%s?%s", generatedUrl, parameters)
+	}
+	return generatedUrl
+}
+
+// FUIDEndpointPool send the FUID requests to one node of the cluster as long as it is healthy, so a user written
+// to FUID is read back from the same node. a node failing with a transient error is marked down for FUID_ENDPOINT_DOWN_TIME
+// and the requests fail over to the next node, the pool stays on that node until it fails in turn
+type FUIDEndpointPool struct {
+	mutex     sync.Mutex
+	endpoints []*FUIDEndpoint
+	current   int
+	downTime  time.Duration
+}
+
+// NewFUIDEndpointPool create the pool of the FUID_ENDPOINTS nodes, or of FUID_IP_ADDRESS and FUID_PORT when FUID_ENDPOINTS is not set.
+// a node given without port uses FUID_PORT
+func NewFUIDEndpointPool(proxyConfig *ProxyConfig) (*FUIDEndpointPool, error) {
+	addresses := GetConfigList("FUID_ENDPOINTS")
+	if len(addresses) == 0 {
+		if viper.GetString("FUID_IP_ADDRESS") == "" {
+			return nil, errors.New("The FUID API IP address is not provided")
+		}
+		addresses = []string{viper.GetString("FUID_IP_ADDRESS")}
+	}
+	pool := &FUIDEndpointPool{downTime: time.Duration(viper.GetInt("FUID_ENDPOINT_DOWN_TIME")) * time.Second}
+	for _, address := range addresses {
+		endpoint, err := parseFUIDEndpoint(address)
+		if err != nil {
+			return nil, err
+		}
+		pool.endpoints = append(pool.endpoints, endpoint)
+	}
+	tlsConfig, err := pool.tlsConfig(proxyConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, endpoint := range pool.endpoints {
+		clientConfig := NewHTTPClientConfig(TargetFUID, proxyConfig)
+		clientConfig.Target = fmt.Sprintf("%s %s", TargetFUID, endpoint.Address())
+		endpoint.client = NewHTTPClient(clientConfig, tlsConfig)
+	}
+	return pool, nil
+}
+
+// parseFUIDEndpoint parse a host or host:port FUID node address
+func parseFUIDEndpoint(address string) (*FUIDEndpoint, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		// no port in the address
+		host, portString = address, ""
+	}
+	port := viper.GetInt("FUID_PORT")
+	if portString != "" {
+		port, err = strconv.Atoi(portString)
+		if err != nil {
+			return nil, errors.Errorf("invalid port in the FUID endpoint '%s'", address)
+		}
+	}
+	if host == "" {
+		return nil, errors.Errorf("invalid FUID endpoint '%s'", address)
+	}
+	if port == 0 {
+		return nil, errors.New("The FUID API port number is not provided")
+	}
+	return &FUIDEndpoint{Host: host, Port: port}, nil
+}
+
+// tlsConfig trust the certificates of the nodes which could be reached, an unreachable node does not stop the startup.
+// the FUID client certificate is presented for mutual TLS when configured
+func (p *FUIDEndpointPool) tlsConfig(proxyConfig *ProxyConfig) (*tls.Config, error) {
+	certificates, err := loadFUIDClientCertificates()
+	if err != nil {
+		return nil, err
+	}
+	caCertPool := x509.NewCertPool()
+	timeout := NewHTTPClientConfig(TargetFUID, proxyConfig).ConnectTimeout
+	for _, endpoint := range p.endpoints {
+		caCert, err := ExtractServerCert(endpoint.Host, endpoint.Port, proxyConfig, timeout)
+		if err != nil {
+			logrus.Warningf("cannot retrieve the TLS certificate of the FUID node %s: %s", endpoint.Address(), err)
+			continue
+		}
+		caCertPool.AppendCertsFromPEM(caCert)
+	}
+	return &tls.Config{
+		RootCAs:            caCertPool,
+		Certificates:       certificates,
+		InsecureSkipVerify: true,
+	}, nil
+}
+
+// Endpoints return the nodes of the pool
+func (p *FUIDEndpointPool) Endpoints() []*FUIDEndpoint {
+	return p.endpoints
+}
+
+// candidates return the nodes in the order they are tried: the current node and the next healthy ones,
+// then the nodes marked down, the one recovering first is tried first
+func (p *FUIDEndpointPool) candidates() []*FUIDEndpoint {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	var healthy, down []*FUIDEndpoint
+	for i := range p.endpoints {
+		endpoint := p.endpoints[(p.current+i)%len(p.endpoints)]
+		if now.Before(endpoint.downUntil) {
+			down = append(down, endpoint)
+		} else {
+			healthy = append(healthy, endpoint)
+		}
+	}
+	sort.SliceStable(down, func(i, j int) bool {
+		return down[i].downUntil.Before(down[j].downUntil)
+	})
+	return append(healthy, down...)
+}
+
+// markUp make the node which answered the current node
+func (p *FUIDEndpointPool) markUp(endpoint *FUIDEndpoint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	endpoint.downUntil = time.Time{}
+	for i, e := range p.endpoints {
+		if e == endpoint && i != p.current {
+			logrus.Warningf("FUID requests failed over from %s to %s", p.endpoints[p.current].Address(), endpoint.Address())
+			p.current = i
+		}
+	}
+}
+
+// markDown exclude a failing node until the down time passed
+func (p *FUIDEndpointPool) markDown(endpoint *FUIDEndpoint, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.endpoints) > 1 {
+		logrus.Warningf("FUID node %s is marked down for %s: %s", endpoint.Address(), p.downTime, err)
+	}
+	endpoint.downUntil = time.Now().Add(p.downTime)
+}
+
+// Do send a request to the nodes until one answers. a request which is not idempotent is only failed over
+// when it was not sent, the circuit of the node was open or the request failed before it was written,
+// as the failing node may have processed it
+func (p *FUIDEndpointPool) Do(path, parameters string, request *HTTPRequest) (*http.Response, error) {
+	var lastErr error
+	for _, node := range p.candidates() {
+		nodeRequest := *request
+		nodeRequest.Url = node.Url(path, parameters)
+		resp, err := node.client.Do(&nodeRequest)
+		if err == nil {
+			p.markUp(node)
+			return resp, nil
+		}
+		if !IsTransient(err) {
+			return nil, err
+		}
+		p.markDown(node, err)
+		lastErr = err
+		if !request.Idempotent && !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrRequestNotSent) {
+			break
+		}
+	}
+	return nil, lastErr
+}
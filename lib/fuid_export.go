@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+const (
+	ExportFormatJSON = "json"
+	ExportFormatCSV  = "csv"
+)
+
+var exportCSVHeader = []string{"NTLMIdentity", "sAMAccountName", "objectGUID", "dn", "mail", "ipv4_addresses", "ipv6_addresses", "groups", "timestamp"}
+
+// ValidateExportFormat return an error when format is not an export format
+func ValidateExportFormat(format string) error {
+	switch strings.ToLower(format) {
+	case ExportFormatJSON, ExportFormatCSV:
+		return nil
+	}
+	return errors.Errorf("unsupported export format '%s', supported formats are json and csv", format)
+}
+
+// ExportUsers write FUID users to w in the given format (json or csv)
+func ExportUsers(users []FUIDUser, format string, w io.Writer) error {
+	if err := ValidateExportFormat(format); err != nil {
+		return err
+	}
+	switch strings.ToLower(format) {
+	case ExportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(&AllUsers{Users: users})
+	case ExportFormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(exportCSVHeader); err != nil {
+			return err
+		}
+		for _, user := range users {
+			record := []string{
+				user.NTLMIdentity,
+				user.SAMAccountName,
+				user.ObjectGUID,
+				user.Dn,
+				user.Mail,
+				strings.Join(user.Ipv4Addresses, ";"),
+				strings.Join(user.Ipv6Addresses, ";"),
+				strings.Join(user.Groups, ";"),
+				user.Timestamp,
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package lib
+
+// FUIDSink publish the identity events to the Forcepoint User ID service
+type FUIDSink struct {
+	controller     *FUIDController
+	displayProcess bool
+}
+
+// NewFUIDSink create an identity sink for FUID
+func NewFUIDSink(controller *FUIDController, displayProcess bool) *FUIDSink {
+	return &FUIDSink{controller: controller, displayProcess: displayProcess}
+}
+
+func (f *FUIDSink) Name() string {
+	return SinkFUID
+}
+
+// Login add the session IP addresses to the user, the user is created when it is not in FUID database
+func (f *FUIDSink) Login(event *IdentityEvent) error {
+	return f.controller.UserManager(event, f.displayProcess)
+}
+
+// Logout delete the session IP addresses from the user
+func (f *FUIDSink) Logout(event *IdentityEvent) error {
+	return f.controller.UserManager(event, f.displayProcess)
+}
+
+// GroupUpdate refresh the user groups from AD
+func (f *FUIDSink) GroupUpdate(event *IdentityEvent) error {
+	return f.controller.GroupManager(event, f.displayProcess)
+}
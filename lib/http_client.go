@@ -0,0 +1,332 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the outbound HTTP targets, each target reads its own settings from the config with the target name as prefix
+const (
+	TargetISEControl = "ISE_CONTROL"
+	TargetISESession = "ISE_SESSION"
+	TargetFUID       = "FUID"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrRequestNotSent is matched by the errors of the requests which failed before they were written, such as a dial or a TLS error
+var ErrRequestNotSent = errors.New("the request was not sent")
+
+// requestNotSentError is returned by an attempt which failed before the request was written
+type requestNotSentError struct {
+	err error
+}
+
+func (e *requestNotSentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestNotSentError) Unwrap() error {
+	return e.err
+}
+
+func (e *requestNotSentError) Is(target error) bool {
+	return target == ErrRequestNotSent
+}
+
+// TransientError is returned when a target is unreachable or keeps failing, the request can be tried again later
+type TransientError struct {
+	Target string
+	Err    error
+}
+
+func (e *TransientError) Error() string {
+	return fmt.Sprintf("%s is temporarily unavailable: %s", e.Target, e.Err)
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// IsTransient report whether err, or any error it wraps, is a TransientError
+func IsTransient(err error) bool {
+	var transientError *TransientError
+	return errors.As(err, &transientError)
+}
+
+// HTTPClientConfig holds the timeouts, retry and circuit breaker settings of a target
+type HTTPClientConfig struct {
+	Target           string
+	ConnectTimeout   time.Duration
+	ReadTimeout      time.Duration
+	Timeout          time.Duration
+	MaxRetries       int
+	RetryBackoff     time.Duration
+	RetryMaxBackoff  time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	Proxy            *ProxyConfig
+	Limiter          *Limiter
+}
+
+// NewHTTPClientConfig read the settings of a target from the config, proxyConfig can be nil to use the environment proxy
+func NewHTTPClientConfig(target string, proxyConfig *ProxyConfig) *HTTPClientConfig {
+	var limiter *Limiter
+	if name, ok := targetLimiters[target]; ok {
+		limiter = GetLimiter(name)
+	}
+	return &HTTPClientConfig{
+		Target:           target,
+		ConnectTimeout:   time.Duration(viper.GetInt(target+"_CONNECT_TIMEOUT")) * time.Second,
+		ReadTimeout:      time.Duration(viper.GetInt(target+"_READ_TIMEOUT")) * time.Second,
+		Timeout:          time.Duration(viper.GetInt(target+"_TIMEOUT")) * time.Second,
+		MaxRetries:       viper.GetInt(target + "_MAX_RETRIES"),
+		RetryBackoff:     time.Duration(viper.GetInt(target+"_RETRY_BACKOFF_MS")) * time.Millisecond,
+		RetryMaxBackoff:  time.Duration(viper.GetInt(target+"_RETRY_MAX_BACKOFF_MS")) * time.Millisecond,
+		BreakerThreshold: viper.GetInt(target + "_BREAKER_THRESHOLD"),
+		BreakerCooldown:  time.Duration(viper.GetInt(target+"_BREAKER_COOLDOWN")) * time.Second,
+		Proxy:            proxyConfig,
+		Limiter:          limiter,
+	}
+}
+
+// HTTPRequest describe a request sent by HTTPClient, the body is kept as bytes so the request can be retried
+type HTTPRequest struct {
+	Method     string
+	Url        string
+	Body       []byte
+	Username   string
+	Password   string
+	Header     http.Header
+	Idempotent bool
+	// Stream is set for the responses processed while they are read, the limiter is released once the headers are
+	// received. the overall timeout bounds the wait for the headers and the read timeout every read of the body
+	Stream bool
+}
+
+// HTTPClient is the HTTP client shared by the ISE and FUID controllers
+type HTTPClient struct {
+	config  *HTTPClientConfig
+	client  *http.Client
+	breaker *CircuitBreaker
+}
+
+// NewHTTPClient create a client for a target
+func NewHTTPClient(config *HTTPClientConfig, tlsConfig *tls.Config) *HTTPClient {
+	dialer := &net.Dialer{Timeout: config.ConnectTimeout}
+	transport := &http.Transport{
+		Proxy:                 config.Proxy.Proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   config.ConnectTimeout,
+		ResponseHeaderTimeout: config.ReadTimeout,
+	}
+	return &HTTPClient{
+		config:  config,
+		client:  &http.Client{Transport: transport},
+		breaker: NewCircuitBreaker(config.Target, config.BreakerThreshold, config.BreakerCooldown),
+	}
+}
+
+// Do send a request, idempotent requests are retried with jittered exponential backoff.
+// the overall timeout covers reading the response body, except for the streamed requests. the body must be closed by the caller
+func (h *HTTPClient) Do(request *HTTPRequest) (*http.Response, error) {
+	attempts := 1
+	if request.Idempotent {
+		attempts += h.config.MaxRetries
+	}
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			backoff := h.backoff(attempt)
+			logrus.Warnf("%s request %s %s failed: %s, retrying in %s", h.config.Target, request.Method, request.Url, lastErr, backoff)
+			time.Sleep(backoff)
+		}
+		if !h.breaker.Allow() {
+			return nil, &TransientError{Target: h.config.Target, Err: ErrCircuitOpen}
+		}
+		resp, err := h.send(request)
+		if err != nil {
+			h.breaker.Failure()
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+			h.breaker.Failure()
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			_ = resp.Body.Close()
+			lastErr = errors.Errorf("status_code: %d, statusReason: %s", resp.StatusCode, resp.Status)
+			continue
+		}
+		h.breaker.Success()
+		return resp, nil
+	}
+	return nil, &TransientError{Target: h.config.Target, Err: errors.Wrapf(lastErr, "%s %s failed after %d attempts", request.Method, request.Url, attempts)}
+}
+
+// send a single attempt of a request, the limiter of the target is held until the response body is read or closed
+func (h *HTTPClient) send(request *HTTPRequest) (*http.Response, error) {
+	release := h.config.Limiter.Acquire()
+	ctx, timeoutCancel := context.Background(), context.CancelFunc(func() {})
+	if request.Stream {
+		ctx, timeoutCancel = context.WithCancel(ctx)
+	} else if h.config.Timeout > 0 {
+		ctx, timeoutCancel = context.WithTimeout(ctx, h.config.Timeout)
+	}
+	cancel := func() {
+		timeoutCancel()
+		release()
+	}
+	var body io.Reader
+	if request.Body != nil {
+		body = bytes.NewReader(request.Body)
+	}
+	// a request whose headers were not written did not reach the server
+	var written int32
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{WroteHeaders: func() { atomic.StoreInt32(&written, 1) }})
+	req, err := http.NewRequestWithContext(ctx, request.Method, request.Url, body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Accept-Language", AccessLanguage)
+	for key, values := range request.Header {
+		req.Header[key] = values
+	}
+	if request.Username != "" {
+		req.SetBasicAuth(request.Username, request.Password)
+	}
+	var headersTimer *time.Timer
+	if request.Stream && h.config.Timeout > 0 {
+		headersTimer = time.AfterFunc(h.config.Timeout, timeoutCancel)
+	}
+	resp, err := h.client.Do(req)
+	if headersTimer != nil {
+		headersTimer.Stop()
+	}
+	if err != nil {
+		cancel()
+		if atomic.LoadInt32(&written) == 0 {
+			return nil, &requestNotSentError{err: err}
+		}
+		return nil, err
+	}
+	if request.Stream {
+		release()
+		resp.Body = &cancelOnCloseBody{ReadCloser: &idleTimeoutBody{ReadCloser: resp.Body, timeout: h.config.ReadTimeout, cancel: timeoutCancel}, cancel: cancel}
+		return resp, nil
+	}
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// backoff return the jittered delay before a retry attempt
+func (h *HTTPClient) backoff(attempt int) time.Duration {
+	backoff := h.config.RetryBackoff << uint(attempt-1)
+	if backoff <= 0 || (h.config.RetryMaxBackoff > 0 && backoff > h.config.RetryMaxBackoff) {
+		backoff = h.config.RetryMaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// cancelOnCloseBody release the request context and the limiter once the response body is closed,
+// the limiter is released as soon as the body is read
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelOnCloseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.cancel()
+	}
+	return n, err
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// idleTimeoutBody cancel the request of a streamed response when a read does not return within the timeout
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	cancel  func()
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	if b.timeout <= 0 {
+		return b.ReadCloser.Read(p)
+	}
+	timer := time.AfterFunc(b.timeout, b.cancel)
+	defer timer.Stop()
+	return b.ReadCloser.Read(p)
+}
+
+// CircuitBreaker stop sending requests to a target after consecutive failures, until a cooldown passed
+type CircuitBreaker struct {
+	mutex     sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+// NewCircuitBreaker create a circuit breaker, a threshold of zero disables it
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{name: name, threshold: threshold, cooldown: cooldown}
+}
+
+// Allow report whether a request can be sent, once the cooldown passed a trial request is allowed
+func (c *CircuitBreaker) Allow() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.threshold <= 0 || c.failures < c.threshold {
+		return true
+	}
+	if time.Now().Before(c.openUntil) {
+		return false
+	}
+	// half-open: allow one trial request, a failure opens the breaker again
+	c.openUntil = time.Now().Add(c.cooldown)
+	return true
+}
+
+// Success close the breaker
+func (c *CircuitBreaker) Success() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures = 0
+}
+
+// Failure count a failure, the breaker opens when the threshold is reached
+func (c *CircuitBreaker) Failure() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures++
+	if c.threshold > 0 && c.failures == c.threshold {
+		c.openUntil = time.Now().Add(c.cooldown)
+		logrus.Warnf("%s circuit breaker opened after %d consecutive failures, requests are paused for %s", c.name, c.failures, c.cooldown)
+	}
+}
@@ -0,0 +1,375 @@
+package lib
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+// the identity sink names used in IDENTITY_SINKS
+const (
+	SinkFUID    = "fuid"
+	SinkSyslog  = "syslog"
+	SinkWebhook = "webhook"
+	SinkKafka   = "kafka"
+)
+
+// the actions taken by the FUID sink for an identity event
+const (
+	ActionCreated       = "created"
+	ActionIPAdded       = ChangeTypeAdd
+	ActionIPModified    = ChangeTypeModify
+	ActionIPDeleted     = ChangeTypeDelete
+	ActionGroupsUpdated = "groups-updated"
+	// ActionCoalesced is the action of an event whose change was merged away, such as a login followed by a logout
+	ActionCoalesced = "coalesced"
+)
+
+// IdentityEvent is an ISE session event delivered to the identity sinks.
+// the sinks are called in the configured order, a sink can enrich the event for the sinks after it
+type IdentityEvent struct {
+	Session     *Sessions
+	User        *FUIDUser
+	LdapElement *LdapElement
+	Action      string
+	Source      string
+	Tags        []string
+}
+
+// IdentitySink receives the login, logout and group update events resolved from the ISE sessions
+type IdentitySink interface {
+	Name() string
+	Login(event *IdentityEvent) error
+	Logout(event *IdentityEvent) error
+	GroupUpdate(event *IdentityEvent) error
+}
+
+// SinkFlusher is implemented by the sinks which buffer events, Flush is called at the end of every poll cycle
+// before the session timestamp is saved. Reset is called instead when the poll is aborted.
+// the events are buffered by ISE source, the polls of the sources run concurrently
+type SinkFlusher interface {
+	Flush(source string) error
+	Reset(source string)
+}
+
+// BatchSink is implemented by the sinks which deliver the events of a poll cycle together.
+// in a SinkSet the batch sinks get the events of the poll before the other sinks get them one by one, so they can enrich them
+type BatchSink interface {
+	IdentitySink
+	DeliverBatch(events []*IdentityEvent) error
+}
+
+// SinkSet fan out the identity events to several sinks.
+// a failing sink does not stop the other sinks, only the errors of the required sinks are returned
+type SinkSet struct {
+	sinks      []IdentitySink
+	required   map[string]bool
+	index      *MappingIndex
+	precedence string
+}
+
+// NewSinkSet create a fan-out over sinks, required lists the names of the sinks whose errors are returned
+func NewSinkSet(sinks []IdentitySink, required []string) *SinkSet {
+	sinkSet := &SinkSet{sinks: sinks, required: make(map[string]bool)}
+	for _, name := range required {
+		sinkSet.required[name] = true
+	}
+	return sinkSet
+}
+
+// NewIdentitySinks create the sinks listed in IDENTITY_SINKS, followed by the mapping index of the active sessions.
+// the ISE controller is used by the sinks which enrich the sessions with ISE services
+func NewIdentitySinks(controller *Controller, displayProcess bool) (*SinkSet, error) {
+	var sinks []IdentitySink
+	var fuidController *FUIDController
+	required := GetConfigList("IDENTITY_SINKS_REQUIRED")
+	for _, name := range GetConfigList("IDENTITY_SINKS") {
+		switch name {
+		case SinkFUID:
+			if _, err := GetLdapAttributeMapping(); err != nil {
+				return nil, err
+			}
+			var err error
+			fuidController, err = NewFUIDController()
+			if err != nil {
+				return nil, err
+			}
+			sgtGroups, err := NewSGTGroupMapper(controller)
+			if err != nil {
+				return nil, err
+			}
+			if sgtGroups != nil {
+				fuidController.AddGroupEnricher(sgtGroups)
+			}
+			if postureGroups := NewPostureGroupMapper(); postureGroups != nil {
+				fuidController.AddGroupEnricher(postureGroups)
+			}
+			if viper.GetBool("FUID_COALESCING_ENABLED") {
+				sinks = append(sinks, NewFUIDBatchSink(fuidController, displayProcess))
+			} else {
+				sinks = append(sinks, NewFUIDSink(fuidController, displayProcess))
+			}
+		case SinkSyslog:
+			syslogSink, err := NewSyslogSink()
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, syslogSink)
+		case SinkWebhook:
+			webhookSink, err := NewWebhookSink()
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, webhookSink)
+		case SinkKafka:
+			kafkaSink, err := NewKafkaSink()
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, kafkaSink)
+			// at-least-once delivery: the session timestamp is saved only after the brokers acknowledged the messages
+			required = append(required, SinkKafka)
+		default:
+			return nil, errors.Errorf("unknown identity sink '%s' in IDENTITY_SINKS", name)
+		}
+		if displayProcess {
+			logrus.Infof("identity sink '%s' is enabled", name)
+		}
+	}
+	if len(sinks) == 0 {
+		return nil, errors.New("no identity sink is configured in IDENTITY_SINKS")
+	}
+	precedence, err := validatePrecedence(viper.GetString("IDENTITY_PRECEDENCE"))
+	if err != nil {
+		return nil, err
+	}
+	index := NewMappingIndex(time.Duration(viper.GetInt("MAPPING_INDEX_TTL")) * time.Hour)
+	// the index is empty after a restart, the IP addresses held in FUID tell which identity the next logins take them from
+	if fuidController != nil && viper.GetBool("MACHINE_AUTH_ENABLED") {
+		if users, err := fuidController.ListUsers(); err != nil {
+			logrus.Warningf("cannot restore the mapping index from FUID: %s", err)
+		} else {
+			restored := index.Restore(users)
+			if displayProcess {
+				logrus.Infof("the mapping index is restored with the IP addresses of %d FUID users", restored)
+			}
+		}
+	}
+	sinkSet := NewSinkSet(append(sinks, index), required)
+	sinkSet.index = index
+	sinkSet.precedence = precedence
+	return sinkSet, nil
+}
+
+// Index return the mapping index of the active sessions
+func (s *SinkSet) Index() *MappingIndex {
+	return s.index
+}
+
+func (s *SinkSet) Name() string {
+	return strings.Join(s.Names(), ",")
+}
+
+// Names return the names of the sinks in fan-out order
+func (s *SinkSet) Names() []string {
+	names := make([]string, 0, len(s.sinks))
+	for _, sink := range s.sinks {
+		names = append(names, sink.Name())
+	}
+	return names
+}
+
+func (s *SinkSet) Login(event *IdentityEvent) error {
+	return s.dispatch(event, IdentitySink.Login)
+}
+
+func (s *SinkSet) Logout(event *IdentityEvent) error {
+	return s.dispatch(event, IdentitySink.Logout)
+}
+
+func (s *SinkSet) GroupUpdate(event *IdentityEvent) error {
+	return s.dispatch(event, IdentitySink.GroupUpdate)
+}
+
+// Flush flush the events of a source in the buffering sinks, the first error of a required sink is returned
+func (s *SinkSet) Flush(source string) error {
+	var requiredErr error
+	for _, sink := range s.sinks {
+		flusher, ok := sink.(SinkFlusher)
+		if !ok {
+			continue
+		}
+		if err := flusher.Flush(source); err != nil {
+			if s.required[sink.Name()] && requiredErr == nil {
+				requiredErr = errors.Wrapf(err, "identity sink %s", sink.Name())
+				continue
+			}
+			logrus.Errorf("identity sink %s failed to flush: %s", sink.Name(), err.Error())
+		}
+	}
+	return requiredErr
+}
+
+// Reset drop the events of a source buffered in the buffering sinks
+func (s *SinkSet) Reset(source string) {
+	for _, sink := range s.sinks {
+		if flusher, ok := sink.(SinkFlusher); ok {
+			flusher.Reset(source)
+		}
+	}
+}
+
+// DispatchBatch deliver the events of a poll cycle in order. the overridden events are dropped, then the batch sinks
+// get the remaining events and the other sinks get them one by one. the first error of a required sink is returned
+func (s *SinkSet) DispatchBatch(events []*IdentityEvent) error {
+	var batchSinks []BatchSink
+	var sinks []IdentitySink
+	for _, sink := range s.sinks {
+		if batchSink, ok := sink.(BatchSink); ok {
+			batchSinks = append(batchSinks, batchSink)
+		} else if sink != IdentitySink(s.index) {
+			sinks = append(sinks, sink)
+		}
+	}
+	if len(batchSinks) == 0 {
+		for _, event := range events {
+			if err := DispatchSession(event, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var accepted []*IdentityEvent
+	for _, event := range events {
+		if s.overridden(event) {
+			continue
+		}
+		// the logouts of the identities losing IP addresses to the login are delivered before it
+		accepted = append(accepted, s.released(event)...)
+		// the index is updated in order, it resolves the precedence of the next events
+		if s.index != nil {
+			if err := DispatchSession(event, s.index); err != nil {
+				logrus.Errorf("identity sink %s failed for user %s: %s", s.index.Name(), event.Session.Username, err.Error())
+			}
+		}
+		accepted = append(accepted, event)
+	}
+	var requiredErr error
+	for _, batchSink := range batchSinks {
+		if err := batchSink.DeliverBatch(accepted); err != nil {
+			if s.required[batchSink.Name()] && requiredErr == nil {
+				requiredErr = errors.Wrapf(err, "identity sink %s", batchSink.Name())
+				continue
+			}
+			logrus.Errorf("identity sink %s failed to deliver %d events: %s", batchSink.Name(), len(accepted), err.Error())
+		}
+	}
+	for _, event := range accepted {
+		handler := sessionHandler(event.Session.State)
+		if handler == nil {
+			continue
+		}
+		if err := s.deliver(sinks, event, handler); err != nil && requiredErr == nil {
+			requiredErr = err
+		}
+	}
+	return requiredErr
+}
+
+// dispatch call a handler on every sink, the first error of a required sink is returned
+func (s *SinkSet) dispatch(event *IdentityEvent, handler func(IdentitySink, *IdentityEvent) error) error {
+	if s.overridden(event) {
+		return nil
+	}
+	var sinks []IdentitySink
+	for _, sink := range s.sinks {
+		if sink != IdentitySink(s.index) {
+			sinks = append(sinks, sink)
+		}
+	}
+	for _, logout := range s.released(event) {
+		if err := s.deliver(sinks, logout, IdentitySink.Logout); err != nil {
+			return err
+		}
+	}
+	return s.deliver(s.sinks, event, handler)
+}
+
+// overridden report whether the event of a user or a machine shares an IP address with an identity having precedence
+func (s *SinkSet) overridden(event *IdentityEvent) bool {
+	if s.index == nil {
+		return false
+	}
+	mapping, ok := s.index.Overridden(event, s.precedence)
+	if ok {
+		logrus.Infof("the %s session of %s is ignored, %s has precedence on IP addresses %v", event.Session.State,
+			event.Session.Username, mapping.User, mapping.IpAddresses)
+	}
+	return ok
+}
+
+// released return the logouts of the identities losing IP addresses to a login, the index moves the IP addresses
+// itself when it gets the login
+func (s *SinkSet) released(event *IdentityEvent) []*IdentityEvent {
+	if s.index == nil {
+		return nil
+	}
+	released := s.index.Released(event)
+	for _, logout := range released {
+		logrus.Infof("the %s session of %s takes IP addresses %v over from %s", event.Session.State, event.Session.Username,
+			logout.Session.IpAddresses, logout.Session.Username)
+	}
+	return released
+}
+
+// deliver call a handler on sinks, the first error of a required sink is returned
+func (s *SinkSet) deliver(sinks []IdentitySink, event *IdentityEvent, handler func(IdentitySink, *IdentityEvent) error) error {
+	var requiredErr error
+	for _, sink := range sinks {
+		if err := handler(sink, event); err != nil {
+			if s.required[sink.Name()] && requiredErr == nil {
+				requiredErr = errors.Wrapf(err, "identity sink %s", sink.Name())
+				continue
+			}
+			logrus.Errorf("identity sink %s failed for user %s: %s", sink.Name(), event.Session.Username, err.Error())
+		}
+	}
+	return requiredErr
+}
+
+// DispatchSession deliver an event to a sink according to the session state
+func DispatchSession(event *IdentityEvent, sink IdentitySink) error {
+	handler := sessionHandler(event.Session.State)
+	if handler == nil {
+		return nil
+	}
+	return handler(sink, event)
+}
+
+// DispatchBatch deliver the events of a poll cycle to a sink
+func DispatchBatch(events []*IdentityEvent, sink IdentitySink) error {
+	if sinkSet, ok := sink.(*SinkSet); ok {
+		return sinkSet.DispatchBatch(events)
+	}
+	for _, event := range events {
+		if err := DispatchSession(event, sink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sessionHandler return the sink handler of a session state, nil for the states which are not delivered
+func sessionHandler(state string) func(IdentitySink, *IdentityEvent) error {
+	switch state {
+	case AUTHENTICATED:
+		return IdentitySink.Login
+	case DISCONNECTED:
+		return IdentitySink.Logout
+	case POSTURED:
+		return IdentitySink.GroupUpdate
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const DefaultISESourceName = "default"
+
+// ISESource is an ISE deployment polled by the consumer, with its own pxGrid account, checkpoint and session rules.
+// the sources are listed in ISE_SOURCES, the PXGRID_* configs make the single default source when ISE_SOURCES is not set
+type ISESource struct {
+	Name                  string         `mapstructure:"NAME"`
+	HostAddress           string         `mapstructure:"PXGRID_HOST_ADDRESS"`
+	Port                  int            `mapstructure:"ISE_PORT"`
+	ClientAccountName     string         `mapstructure:"PXGRID_CLIENT_ACCOUNT_NAME"`
+	ClientAccountPassword string         `mapstructure:"PXGRID_CLIENT_ACCOUNT_PASSWORD"`
+	TimestampPath         string         `mapstructure:"SESSION_LATEST_TIMESTAMP_PATH"`
+	Rules                 []*SessionRule `mapstructure:"SESSION_RULES"`
+	RulesDefaultAction    string         `mapstructure:"SESSION_RULES_DEFAULT_ACTION"`
+	ruleSet               *RuleSet
+	log                   *logrus.Entry
+}
+
+// DefaultISESource create the source of the global PXGRID_* configs
+func DefaultISESource() (*ISESource, error) {
+	source := &ISESource{
+		Name:                  DefaultISESourceName,
+		HostAddress:           viper.GetString("PXGRID_HOST_ADDRESS"),
+		Port:                  viper.GetInt("ISE_PORT"),
+		ClientAccountName:     viper.GetString("PXGRID_CLIENT_ACCOUNT_NAME"),
+		ClientAccountPassword: viper.GetString("PXGRID_CLIENT_ACCOUNT_PASSWORD"),
+		TimestampPath:         viper.GetString("SESSION_LATEST_TIMESTAMP_PATH"),
+	}
+	if err := source.init(); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// NewISESources create the sources listed in ISE_SOURCES, or the default source.
+// the port, the session rules and their default action not set on a source are taken from the global configs
+func NewISESources() ([]*ISESource, error) {
+	if !viper.IsSet("ISE_SOURCES") {
+		source, err := DefaultISESource()
+		if err != nil {
+			return nil, err
+		}
+		return []*ISESource{source}, nil
+	}
+	var sources []*ISESource
+	if err := viper.UnmarshalKey("ISE_SOURCES", &sources); err != nil {
+		return nil, errors.Wrap(err, "invalid ISE_SOURCES")
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no ISE source is configured in ISE_SOURCES")
+	}
+	names := make(map[string]bool)
+	timestampPaths := make(map[string]bool)
+	for i, source := range sources {
+		if source.Name == "" {
+			source.Name = fmt.Sprintf("ise-%d", i+1)
+		}
+		if names[source.Name] {
+			return nil, errors.Errorf("the ISE source name %s is used more than once", source.Name)
+		}
+		names[source.Name] = true
+		if source.TimestampPath == "" {
+			return nil, errors.Errorf("the ISE source %s has no SESSION_LATEST_TIMESTAMP_PATH", source.Name)
+		}
+		// every source needs its own checkpoint, a shared one would skip sessions
+		if timestampPaths[source.TimestampPath] {
+			return nil, errors.Errorf("the SESSION_LATEST_TIMESTAMP_PATH of the ISE source %s is used by another source", source.Name)
+		}
+		timestampPaths[source.TimestampPath] = true
+		if err := source.init(); err != nil {
+			return nil, err
+		}
+	}
+	return sources, nil
+}
+
+// FindISESource return the source with a name, the first source when name is empty
+func FindISESource(sources []*ISESource, name string) (*ISESource, error) {
+	for _, source := range sources {
+		if name == "" || source.Name == name {
+			return source, nil
+		}
+	}
+	return nil, errors.Errorf("unknown ISE source '%s'", name)
+}
+
+// init apply the global defaults and compile the session rules
+func (s *ISESource) init() error {
+	if s.Port == 0 {
+		s.Port = viper.GetInt("ISE_PORT")
+	}
+	if s.Rules == nil {
+		if err := viper.UnmarshalKey("SESSION_RULES", &s.Rules); err != nil {
+			return errors.Wrap(err, "invalid SESSION_RULES")
+		}
+	}
+	if s.RulesDefaultAction == "" {
+		s.RulesDefaultAction = viper.GetString("SESSION_RULES_DEFAULT_ACTION")
+	}
+	ruleSet, err := NewRuleSet(s.Rules, s.RulesDefaultAction)
+	if err != nil {
+		return errors.Wrapf(err, "ISE source %s", s.Name)
+	}
+	s.ruleSet = ruleSet
+	s.log = logrus.WithField("source", s.Name)
+	return nil
+}
+
+// Validate ensure the source has an ISE server and credentials
+func (s *ISESource) Validate() error {
+	if s.HostAddress == "" {
+		return errors.Errorf("the ISE server PXGRID_HOST_ADDRESS of the ISE source %s is not provided", s.Name)
+	}
+	if s.ClientAccountName == "" {
+		return errors.Errorf("Ise client username of the ISE source %s is not provided", s.Name)
+	}
+	if s.ClientAccountPassword == "" {
+		return errors.Errorf("Ise client password of the ISE source %s is not provided", s.Name)
+	}
+	return nil
+}
+
+// EndpointUrl return the URL of a pxGrid control endpoint
+func (s *ISESource) EndpointUrl(endpointName string) string {
+	return fmt.Sprintf("https://%s:%d/%s", s.HostAddress, s.Port, endpointName)
+}
+
+// RuleSet return the session rules of the source
+func (s *ISESource) RuleSet() *RuleSet {
+	return s.ruleSet
+}
+
+// Log return the logger tagged with the source name
+func (s *ISESource) Log() *logrus.Entry {
+	if s.log == nil {
+		return logrus.WithField("source", s.Name)
+	}
+	return s.log
+}
@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/xdg/scram"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TargetKafka     = "KAFKA"
+	KafkaKeyUser    = "user"
+	KafkaKeyMac     = "mac"
+	saslPlain       = "PLAIN"
+	saslScramSHA256 = "SCRAM-SHA-256"
+	saslScramSHA512 = "SCRAM-SHA-512"
+)
+
+// KafkaIdentity is the FUID identity resolved for a session
+type KafkaIdentity struct {
+	NTLMIdentity   string   `json:"ntlmIdentity,omitempty"`
+	SAMAccountName string   `json:"sAMAccountName,omitempty"`
+	ObjectGUID     string   `json:"objectGUID,omitempty"`
+	Dn             string   `json:"dn,omitempty"`
+	Groups         []string `json:"groups,omitempty"`
+}
+
+// KafkaMessage is the JSON value published for every processed session
+type KafkaMessage struct {
+	Event    string         `json:"event"`
+	Action   string         `json:"action,omitempty"`
+	Session  *Sessions      `json:"session"`
+	Identity *KafkaIdentity `json:"identity,omitempty"`
+	Source   string         `json:"source,omitempty"`
+	Tags     []string       `json:"tags,omitempty"`
+}
+
+// KafkaSink publish the processed sessions to a Kafka topic.
+// the messages of a poll cycle are sent on Flush, which returns only after the brokers acknowledged them.
+// the messages are buffered by ISE source, a source flushes only the messages of its own poll
+type KafkaSink struct {
+	mutex    sync.Mutex
+	producer sarama.SyncProducer
+	topic    string
+	keyBy    string
+	buffers  map[string][]*sarama.ProducerMessage
+}
+
+// NewKafkaSink create a Kafka sink from the KAFKA_* configs
+func NewKafkaSink() (*KafkaSink, error) {
+	brokers := GetConfigList("KAFKA_BROKERS")
+	if len(brokers) == 0 {
+		return nil, errors.New("the Kafka brokers KAFKA_BROKERS are not provided")
+	}
+	config, err := NewKafkaConfig()
+	if err != nil {
+		return nil, err
+	}
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot connect to the Kafka brokers")
+	}
+	return NewKafkaSinkWithProducer(producer, viper.GetString("KAFKA_TOPIC"), viper.GetString("KAFKA_MESSAGE_KEY"))
+}
+
+// NewKafkaSinkWithProducer create a Kafka sink publishing with the given producer
+func NewKafkaSinkWithProducer(producer sarama.SyncProducer, topic, keyBy string) (*KafkaSink, error) {
+	if topic == "" {
+		return nil, errors.New("the Kafka topic KAFKA_TOPIC is not provided")
+	}
+	keyBy = strings.ToLower(keyBy)
+	if keyBy != KafkaKeyUser && keyBy != KafkaKeyMac {
+		return nil, errors.Errorf("unsupported KAFKA_MESSAGE_KEY '%s', supported keys are user and mac", keyBy)
+	}
+	return &KafkaSink{producer: producer, topic: topic, keyBy: keyBy, buffers: make(map[string][]*sarama.ProducerMessage)}, nil
+}
+
+// NewKafkaConfig build the producer config with the TLS and SASL settings, all in-sync replicas must acknowledge a message
+func NewKafkaConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.ClientID = viper.GetString("KAFKA_CLIENT_ID")
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	config.Producer.Retry.Max = viper.GetInt("KAFKA_MAX_RETRIES")
+	config.Producer.Timeout = time.Duration(viper.GetInt("KAFKA_TIMEOUT")) * time.Second
+	config.Net.DialTimeout = time.Duration(viper.GetInt("KAFKA_TIMEOUT")) * time.Second
+	if viper.GetString("KAFKA_VERSION") != "" {
+		version, err := sarama.ParseKafkaVersion(viper.GetString("KAFKA_VERSION"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid KAFKA_VERSION")
+		}
+		config.Version = version
+	}
+	if viper.GetBool("KAFKA_TLS_ENABLED") {
+		tlsConfig := &tls.Config{InsecureSkipVerify: viper.GetBool("KAFKA_TLS_INSECURE_SKIP_VERIFY")}
+		if viper.GetString("KAFKA_TLS_CA_PATH") != "" {
+			caCert, err := ioutil.ReadFile(viper.GetString("KAFKA_TLS_CA_PATH"))
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(caCert)
+			tlsConfig.RootCAs = pool
+		}
+		if viper.GetString("KAFKA_TLS_CERT_PATH") != "" {
+			cert, err := tls.LoadX509KeyPair(viper.GetString("KAFKA_TLS_CERT_PATH"), viper.GetString("KAFKA_TLS_KEY_PATH"))
+			if err != nil {
+				return nil, errors.Wrap(err, "cannot load the Kafka client certificate")
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+	mechanism := strings.ToUpper(viper.GetString("KAFKA_SASL_MECHANISM"))
+	if mechanism != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.User = viper.GetString("KAFKA_SASL_USERNAME")
+		config.Net.SASL.Password = viper.GetString("KAFKA_SASL_PASSWORD")
+		switch mechanism {
+		case saslPlain:
+			config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case saslScramSHA256:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hashGenerator: sha256.New} }
+		case saslScramSHA512:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hashGenerator: sha512.New} }
+		default:
+			return nil, errors.Errorf("unsupported KAFKA_SASL_MECHANISM '%s', supported mechanisms are %s, %s and %s",
+				mechanism, saslPlain, saslScramSHA256, saslScramSHA512)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid Kafka config")
+	}
+	return config, nil
+}
+
+func (k *KafkaSink) Name() string {
+	return SinkKafka
+}
+
+func (k *KafkaSink) Login(event *IdentityEvent) error {
+	return k.add("login", event)
+}
+
+func (k *KafkaSink) Logout(event *IdentityEvent) error {
+	return k.add("logout", event)
+}
+
+func (k *KafkaSink) GroupUpdate(event *IdentityEvent) error {
+	return k.add("group-update", event)
+}
+
+// Flush publish the buffered messages of a source and wait for the brokers acknowledgement.
+// on failure the messages are dropped, they are published again when the sessions are read in the next poll
+func (k *KafkaSink) Flush(source string) error {
+	k.mutex.Lock()
+	messages := k.buffers[source]
+	delete(k.buffers, source)
+	k.mutex.Unlock()
+	if len(messages) == 0 {
+		return nil
+	}
+	if err := k.producer.SendMessages(messages); err != nil {
+		return &TransientError{Target: TargetKafka, Err: errors.Wrapf(err, "cannot publish %d messages to topic %s", len(messages), k.topic)}
+	}
+	logrus.Debugf("published %d messages to Kafka topic %s", len(messages), k.topic)
+	return nil
+}
+
+// Reset drop the buffered messages of a source whose poll is aborted, they are published again when the sessions
+// are read in the next poll
+func (k *KafkaSink) Reset(source string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	delete(k.buffers, source)
+}
+
+// Close close the producer
+func (k *KafkaSink) Close() error {
+	return k.producer.Close()
+}
+
+// add encode an event and buffer it until the end of the poll cycle
+func (k *KafkaSink) add(eventName string, event *IdentityEvent) error {
+	message := &KafkaMessage{Event: eventName, Action: event.Action, Session: event.Session, Source: event.Source, Tags: event.Tags}
+	if event.User != nil {
+		message.Identity = &KafkaIdentity{
+			NTLMIdentity:   event.User.NTLMIdentity,
+			SAMAccountName: event.User.SAMAccountName,
+			ObjectGUID:     event.User.ObjectGUID,
+			Dn:             event.User.Dn,
+			Groups:         event.User.Groups,
+		}
+	}
+	value, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	producerMessage := &sarama.ProducerMessage{Topic: k.topic, Value: sarama.ByteEncoder(value)}
+	if key := k.messageKey(event); key != "" {
+		producerMessage.Key = sarama.StringEncoder(key)
+	}
+	if event.Session.Timestamp != nil {
+		producerMessage.Timestamp = *event.Session.Timestamp
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.buffers[event.Source] = append(k.buffers[event.Source], producerMessage)
+	return nil
+}
+
+// messageKey return the user or the MAC address of an event, messages with the same key go to the same partition
+func (k *KafkaSink) messageKey(event *IdentityEvent) string {
+	if k.keyBy == KafkaKeyMac {
+		return normalizeMac(event.Session.MacAddress)
+	}
+	return sessionIdentity(event)
+}
+
+// scramClient implements sarama.SCRAMClient
+type scramClient struct {
+	hashGenerator scram.HashGeneratorFcn
+	conversation  *scram.ClientConversation
+}
+
+func (s *scramClient) Begin(userName, password, authzID string) error {
+	client, err := s.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	s.conversation = client.NewConversation()
+	return nil
+}
+
+func (s *scramClient) Step(challenge string) (string, error) {
+	return s.conversation.Step(challenge)
+}
+
+func (s *scramClient) Done() bool {
+	return s.conversation.Done()
+}
@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+// recordingProducer keep the messages sent to the mock producer, the mock checks the values only
+type recordingProducer struct {
+	*mocks.SyncProducer
+	messages []*sarama.ProducerMessage
+}
+
+func (r *recordingProducer) SendMessages(messages []*sarama.ProducerMessage) error {
+	r.messages = append(r.messages, messages...)
+	return r.SyncProducer.SendMessages(messages)
+}
+
+func newTestKafkaSink(t *testing.T, keyBy string) (*KafkaSink, *recordingProducer) {
+	producer := &recordingProducer{SyncProducer: mocks.NewSyncProducer(t, nil)}
+	sink, err := NewKafkaSinkWithProducer(producer, "identities", keyBy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink, producer
+}
+
+func newTestKafkaEvent(state string) *IdentityEvent {
+	timestamp := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	return &IdentityEvent{
+		Session: &Sessions{
+			Timestamp:            &timestamp,
+			State:                state,
+			Username:             "jdoe",
+			IpAddresses:          []string{"10.0.0.1"},
+			MacAddress:           "aa-bb-cc-dd-ee-ff",
+			AdUserNetBiosName:    "EXAMPLE",
+			AdUserSamAccountName: "jdoe",
+		},
+		User:   &FUIDUser{NTLMIdentity: "EXAMPLE\\jdoe", ObjectGUID: "00112233-4455-6677-8899-aabbccddeeff", Groups: []string{"Sales"}},
+		Action: ActionIPAdded,
+		Source: "ise1",
+		Tags:   []string{"vpn"},
+	}
+}
+
+func TestKafkaSinkMessageKeys(t *testing.T) {
+	tests := []struct {
+		keyBy string
+		key   string
+	}{
+		{KafkaKeyUser, "EXAMPLE\\jdoe"},
+		{KafkaKeyMac, "AA:BB:CC:DD:EE:FF"},
+	}
+	for _, test := range tests {
+		sink, producer := newTestKafkaSink(t, test.keyBy)
+		producer.ExpectSendMessageAndSucceed()
+		if err := sink.Login(newTestKafkaEvent(AUTHENTICATED)); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Flush("ise1"); err != nil {
+			t.Fatalf("key by %s: %s", test.keyBy, err)
+		}
+		if len(producer.messages) != 1 {
+			t.Fatalf("key by %s: %d messages published instead of 1", test.keyBy, len(producer.messages))
+		}
+		key, err := producer.messages[0].Key.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(key) != test.key {
+			t.Errorf("key by %s: the message key is %s instead of %s", test.keyBy, key, test.key)
+		}
+		_ = sink.Close()
+	}
+}
+
+func TestKafkaSinkPayload(t *testing.T) {
+	sink, producer := newTestKafkaSink(t, KafkaKeyUser)
+	var messages []KafkaMessage
+	checker := func(value []byte) error {
+		var message KafkaMessage
+		if err := json.Unmarshal(value, &message); err != nil {
+			return err
+		}
+		messages = append(messages, message)
+		return nil
+	}
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(checker)
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(checker)
+	loginEvent := newTestKafkaEvent(AUTHENTICATED)
+	if err := sink.Login(loginEvent); err != nil {
+		t.Fatal(err)
+	}
+	logout := newTestKafkaEvent(DISCONNECTED)
+	logout.Action = ActionIPDeleted
+	if err := sink.Logout(logout); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.messages) != 0 {
+		t.Fatal("the messages are published before Flush")
+	}
+	if err := sink.Flush("ise1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("%d messages published instead of 2", len(messages))
+	}
+	login := messages[0]
+	if login.Event != "login" || login.Action != ActionIPAdded || login.Source != "ise1" || len(login.Tags) != 1 || login.Tags[0] != "vpn" {
+		t.Errorf("unexpected login message %+v", login)
+	}
+	if login.Session == nil || login.Session.Username != "jdoe" || login.Session.State != AUTHENTICATED {
+		t.Errorf("unexpected login session %+v", login.Session)
+	}
+	if login.Identity == nil || login.Identity.NTLMIdentity != "EXAMPLE\\jdoe" ||
+		login.Identity.ObjectGUID != "00112233-4455-6677-8899-aabbccddeeff" || len(login.Identity.Groups) != 1 {
+		t.Errorf("unexpected login identity %+v", login.Identity)
+	}
+	if messages[1].Event != "logout" || messages[1].Action != ActionIPDeleted {
+		t.Errorf("unexpected logout message %+v", messages[1])
+	}
+	if !producer.messages[0].Timestamp.Equal(*loginEvent.Session.Timestamp) {
+		t.Errorf("the message timestamp is %s instead of the session timestamp", producer.messages[0].Timestamp)
+	}
+	_ = sink.Close()
+}
+
+func TestKafkaSinkPublishFailure(t *testing.T) {
+	sink, producer := newTestKafkaSink(t, KafkaKeyUser)
+	producer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+	if err := sink.Login(newTestKafkaEvent(AUTHENTICATED)); err != nil {
+		t.Fatal(err)
+	}
+	err := sink.Flush("ise1")
+	if !IsTransient(err) {
+		t.Fatalf("a publish failure returned %v instead of a TransientError", err)
+	}
+	if !errors.Is(err, sarama.ErrNotEnoughReplicas) {
+		t.Errorf("the TransientError does not wrap the producer error: %s", err)
+	}
+	// the failed messages are dropped, they are published again when the sessions are read in the next poll
+	if err := sink.Flush("ise1"); err != nil {
+		t.Errorf("the failed messages were kept: %s", err)
+	}
+	_ = sink.Close()
+}
+
+func TestKafkaSinkReset(t *testing.T) {
+	sink, producer := newTestKafkaSink(t, KafkaKeyUser)
+	if err := sink.Login(newTestKafkaEvent(AUTHENTICATED)); err != nil {
+		t.Fatal(err)
+	}
+	sink.Reset("ise1")
+	if err := sink.Flush("ise1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.messages) != 0 {
+		t.Errorf("%d messages of an aborted poll were published", len(producer.messages))
+	}
+	_ = sink.Close()
+}
+
+func TestKafkaSinkSources(t *testing.T) {
+	sink, producer := newTestKafkaSink(t, KafkaKeyUser)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	other := newTestKafkaEvent(AUTHENTICATED)
+	other.Source = "ise2"
+	if err := sink.Login(newTestKafkaEvent(AUTHENTICATED)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Login(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Flush("ise1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.messages) != 1 {
+		t.Fatalf("the flush of a source published %d messages instead of 1", len(producer.messages))
+	}
+	sink.Reset("ise1")
+	if err := sink.Flush("ise2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.messages) != 2 {
+		t.Errorf("the reset of a source dropped the messages of another source")
+	}
+	_ = sink.Close()
+}
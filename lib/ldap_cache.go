@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	DefaultMetrics.Describe("fuid_ise_ldap_cache_hits_total", MetricCounter, "AD lookups served by the LDAP cache")
+	DefaultMetrics.Describe("fuid_ise_ldap_cache_misses_total", MetricCounter, "AD lookups sent to AD")
+	DefaultMetrics.Describe("fuid_ise_ldap_cache_entries", MetricGauge, "AD lookups in the LDAP cache")
+}
+
+// LdapLookupError is returned when an AD search does not match exactly one object, the result is cached as a negative lookup
+type LdapLookupError struct {
+	Username string
+	Matches  int
+}
+
+func (e *LdapLookupError) Error() string {
+	if e.Matches == 0 {
+		return fmt.Sprintf("could not find use %s in LDAP database", e.Username)
+	}
+	return fmt.Sprintf("multiple user with name: %s found in LDAP database", e.Username)
+}
+
+// LdapCache keep the AD lookups of the session accounts by domain and sAMAccountName.
+// the objects found are kept LDAP_CACHE_TTL seconds, the accounts which are not in AD, match several objects or
+// whose object cannot be read are kept LDAP_CACHE_NEGATIVE_TTL seconds, so they are not searched again for every session
+type LdapCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     *TTLCache
+}
+
+type ldapCacheEntry struct {
+	element *LdapElement
+	err     error
+}
+
+var (
+	ldapCacheOnce sync.Once
+	ldapCache     *LdapCache
+)
+
+// NewLdapCache create an LDAP cache of at most maxEntries lookups, a ttl of zero disables the positive or the negative
+// lookups and a maxEntries of zero is unlimited
+func NewLdapCache(ttl, negativeTTL time.Duration, maxEntries int) *LdapCache {
+	return &LdapCache{ttl: ttl, negativeTTL: negativeTTL, entries: NewTTLCache(maxEntries, "fuid_ise_ldap_cache_entries", nil)}
+}
+
+// GetLdapCache return the LDAP cache of the process, created from the LDAP_CACHE_TTL, LDAP_CACHE_NEGATIVE_TTL and
+// LDAP_CACHE_MAX_ENTRIES configs
+func GetLdapCache() *LdapCache {
+	ldapCacheOnce.Do(func() {
+		ldapCache = NewLdapCache(time.Duration(viper.GetInt("LDAP_CACHE_TTL"))*time.Second,
+			time.Duration(viper.GetInt("LDAP_CACHE_NEGATIVE_TTL"))*time.Second, viper.GetInt("LDAP_CACHE_MAX_ENTRIES"))
+	})
+	return ldapCache
+}
+
+// ldapCacheKey return the cache key of an account, its domain and sAMAccountName
+func ldapCacheKey(account *SessionAccount) string {
+	return strings.ToLower(account.NTLMIdentity())
+}
+
+// Get return the cached lookup of an account, the object found or the LdapLookupError or LdapAttributeError.
+// ok is false when the account must be searched in AD
+func (c *LdapCache) Get(account *SessionAccount) (element *LdapElement, ok bool, err error) {
+	value, ok := c.entries.Get(ldapCacheKey(account))
+	if !ok {
+		DefaultMetrics.Add("fuid_ise_ldap_cache_misses_total", nil, 1)
+		return nil, false, nil
+	}
+	DefaultMetrics.Add("fuid_ise_ldap_cache_hits_total", nil, 1)
+	entry := value.(*ldapCacheEntry)
+	if entry.err != nil {
+		return nil, true, entry.err
+	}
+	return entry.element, true, nil
+}
+
+// Put cache the result of an AD search, only the objects found, the LdapLookupError and the LdapAttributeError are cached
+func (c *LdapCache) Put(account *SessionAccount, element *LdapElement, err error) {
+	entry := &ldapCacheEntry{element: element}
+	ttl := c.ttl
+	if err != nil {
+		var lookupErr *LdapLookupError
+		var attributeErr *LdapAttributeError
+		if !errors.As(err, &lookupErr) && !errors.As(err, &attributeErr) {
+			return
+		}
+		entry.err = err
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	c.entries.Put(ldapCacheKey(account), entry, ttl)
+}
+
+// Flush remove every cached lookup, the number of removed lookups is returned
+func (c *LdapCache) Flush() int {
+	return c.entries.Flush()
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLdapCache(t *testing.T) {
+	cache := NewLdapCache(time.Hour, 50*time.Millisecond, 0)
+	found := &SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: "jdoe"}
+	missing := &SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: "nobody"}
+	unreachable := &SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: "asmith"}
+	element := &LdapElement{DN: "CN=jdoe,DC=example,DC=com", Attributes: Attributes{ObjectGUID: "guid-jdoe"}}
+	cache.Put(found, element, nil)
+	cache.Put(missing, nil, &LdapLookupError{Username: "nobody"})
+	// the errors of AD itself are not cached, the next session searches the account again
+	cache.Put(unreachable, nil, errors.New("LDAP Result Code 200: connection refused"))
+
+	// the accounts are matched case insensitive
+	cached, ok, err := cache.Get(&SessionAccount{NetBiosName: "example", SAMAccountName: "JDOE"})
+	if !ok || err != nil || cached != element {
+		t.Errorf("the found account is cached as %v, %v and %v", cached, ok, err)
+	}
+	_, ok, err = cache.Get(missing)
+	var lookupErr *LdapLookupError
+	if !ok || !errors.As(err, &lookupErr) {
+		t.Errorf("the missing account is cached as %v and %v", ok, err)
+	}
+	if _, ok, _ := cache.Get(unreachable); ok {
+		t.Error("an AD error is cached")
+	}
+	// the negative lookups expire first
+	time.Sleep(100 * time.Millisecond)
+	if _, ok, _ := cache.Get(missing); ok {
+		t.Error("the missing account is cached after the negative TTL")
+	}
+	if _, ok, _ := cache.Get(found); !ok {
+		t.Error("the found account expired with the negative TTL")
+	}
+	cache.Put(missing, nil, &LdapLookupError{Username: "nobody", Matches: 2})
+	if count := cache.Flush(); count != 2 {
+		t.Errorf("%d lookups flushed instead of 2", count)
+	}
+	if _, ok, _ := cache.Get(found); ok {
+		t.Error("the found account is cached after the flush")
+	}
+}
+
+func TestLdapCacheDisabled(t *testing.T) {
+	account := &SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: "jdoe"}
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		negativeTTL time.Duration
+		element     *LdapElement
+		err         error
+	}{
+		{"zero TTL", 0, time.Hour, &LdapElement{DN: "CN=jdoe,DC=example,DC=com"}, nil},
+		{"zero negative TTL", time.Hour, 0, nil, &LdapLookupError{Username: "jdoe"}},
+	}
+	for _, test := range tests {
+		cache := NewLdapCache(test.ttl, test.negativeTTL, 0)
+		cache.Put(account, test.element, test.err)
+		if _, ok, _ := cache.Get(account); ok {
+			t.Errorf("%s: the lookup is cached", test.name)
+		}
+	}
+	// the oldest lookups are evicted once the cache is full
+	cache := NewLdapCache(time.Hour, time.Hour, 2)
+	for _, name := range []string{"a", "b", "c"} {
+		cache.Put(&SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: name}, &LdapElement{DN: "CN=" + name}, nil)
+	}
+	if _, ok, _ := cache.Get(&SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: "a"}); ok {
+		t.Error("the oldest lookup is not evicted")
+	}
+	if _, ok, _ := cache.Get(&SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: "c"}); !ok {
+		t.Error("the last lookup is evicted")
+	}
+}
+
+func TestFUIDSkipsAccountsMissingFromAD(t *testing.T) {
+	cache := NewLdapCache(time.Hour, time.Hour, 0)
+	useTestLdapCache(t, cache)
+	cache.Put(&SessionAccount{NetBiosName: "EXAMPLE", SAMAccountName: "nobody"}, nil, &LdapLookupError{Username: "nobody"})
+
+	// a session of an account cached as missing from AD is skipped, it does not fail the poll
+	client := newFakeFUIDClient()
+	controller := &FUIDController{client: client, cache: NewFUIDUserCache(0, 0)}
+	event := newTestSessionEvent("nobody", AUTHENTICATED, "10.0.0.1")
+	if err := controller.UserManager(event, false); err != nil {
+		t.Errorf("the session of an account missing from AD failed: %s", err)
+	}
+	if event.User != nil || len(client.requests) != 0 {
+		t.Errorf("the skipped session wrote %v to FUID", client.requests)
+	}
+
+	// the coalesced events of the other users are written
+	client = newFakeFUIDClient(&FUIDUser{NTLMIdentity: "EXAMPLE\\jdoe", ObjectGUID: "guid-jdoe"})
+	controller = &FUIDController{client: client, cache: NewFUIDUserCache(0, 0)}
+	events := []*IdentityEvent{
+		newTestSessionEvent("nobody", AUTHENTICATED, "10.0.0.1"),
+		newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.2"),
+	}
+	if err := controller.BatchManager(events, false); err != nil {
+		t.Errorf("the batch with an account missing from AD failed: %s", err)
+	}
+	if requests := strings.Join(client.requests, "|"); requests != "add guid-jdoe 10.0.0.2" {
+		t.Errorf("FUID got the requests %s", requests)
+	}
+	if events[0].Action != "" || events[1].Action != ActionIPAdded {
+		t.Errorf("the actions are '%s' and '%s'", events[0].Action, events[1].Action)
+	}
+}
@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// the errors of the binary attribute codec, the returned errors wrap them with the details
+var (
+	ErrMissingAttribute  = errors.New("the attribute is missing")
+	ErrInvalidObjectGUID = errors.New("invalid objectGUID")
+	ErrInvalidObjectSid  = errors.New("invalid objectSid")
+)
+
+const (
+	objectGUIDLength = 16
+	// a SID has a revision, a sub-authority count, a 6 bytes identifier authority and up to 15 sub-authorities of 4 bytes
+	objectSidHeaderLength      = 8
+	objectSidMaxSubAuthorities = 15
+	objectSidRevision          = 1
+)
+
+// LdapAttributeError is returned when an attribute of an LDAP entry is missing or cannot be decoded
+type LdapAttributeError struct {
+	DN        string
+	Attribute string
+	Err       error
+}
+
+func (e *LdapAttributeError) Error() string {
+	return fmt.Sprintf("the attribute %s of the LDAP entry %s is not valid: %s", e.Attribute, e.DN, e.Err)
+}
+
+func (e *LdapAttributeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeObjectGUID decode the 16 bytes of an AD objectGUID into its string form.
+// the first three groups are stored little-endian, 0x33221100 5544 7766 8899 aabbccddeeff is 00112233-4455-6677-8899-aabbccddeeff
+func DecodeObjectGUID(raw []byte) (string, error) {
+	if len(raw) == 0 {
+		return "", ErrMissingAttribute
+	}
+	if len(raw) != objectGUIDLength {
+		return "", errors.Wrapf(ErrInvalidObjectGUID, "%d bytes instead of %d", len(raw), objectGUIDLength)
+	}
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x", binary.LittleEndian.Uint32(raw[0:4]), binary.LittleEndian.Uint16(raw[4:6]),
+		binary.LittleEndian.Uint16(raw[6:8]), raw[8:10], raw[10:16]), nil
+}
+
+// EncodeObjectGUID encode the string form of an objectGUID into its 16 bytes, as stored in AD
+func EncodeObjectGUID(guid string) ([]byte, error) {
+	parts := strings.Split(guid, "-")
+	if len(parts) != 5 || len(parts[0]) != 8 || len(parts[1]) != 4 || len(parts[2]) != 4 || len(parts[3]) != 4 || len(parts[4]) != 12 {
+		return nil, errors.Wrapf(ErrInvalidObjectGUID, "'%s' is not in format xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", guid)
+	}
+	data, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidObjectGUID, "'%s' is not hexadecimal", guid)
+	}
+	raw := make([]byte, objectGUIDLength)
+	binary.LittleEndian.PutUint32(raw[0:4], binary.BigEndian.Uint32(data[0:4]))
+	binary.LittleEndian.PutUint16(raw[4:6], binary.BigEndian.Uint16(data[4:6]))
+	binary.LittleEndian.PutUint16(raw[6:8], binary.BigEndian.Uint16(data[6:8]))
+	copy(raw[8:], data[8:])
+	return raw, nil
+}
+
+// DecodeObjectSid decode the binary form of an AD objectSid into its string form, S-1-5-21-1004336348-1177238915-682003330-512
+func DecodeObjectSid(raw []byte) (string, error) {
+	if len(raw) == 0 {
+		return "", ErrMissingAttribute
+	}
+	if len(raw) < objectSidHeaderLength {
+		return "", errors.Wrapf(ErrInvalidObjectSid, "%d bytes, a SID has at least %d", len(raw), objectSidHeaderLength)
+	}
+	if raw[0] != objectSidRevision {
+		return "", errors.Wrapf(ErrInvalidObjectSid, "unknown revision %d", raw[0])
+	}
+	count := int(raw[1])
+	if count > objectSidMaxSubAuthorities {
+		return "", errors.Wrapf(ErrInvalidObjectSid, "%d sub-authorities, a SID has at most %d", count, objectSidMaxSubAuthorities)
+	}
+	if len(raw) != objectSidHeaderLength+4*count {
+		return "", errors.Wrapf(ErrInvalidObjectSid, "%d bytes for %d sub-authorities", len(raw), count)
+	}
+	var authority uint64
+	for _, b := range raw[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+	var sid strings.Builder
+	sid.WriteString("S-" + strconv.Itoa(int(raw[0])) + "-")
+	// the authorities which do not fit in 32 bits are written in hexadecimal
+	if authority >= 1<<32 {
+		sid.WriteString(fmt.Sprintf("0x%012X", authority))
+	} else {
+		sid.WriteString(strconv.FormatUint(authority, 10))
+	}
+	for i := 0; i < count; i++ {
+		offset := objectSidHeaderLength + 4*i
+		sid.WriteString("-" + strconv.FormatUint(uint64(binary.LittleEndian.Uint32(raw[offset:offset+4])), 10))
+	}
+	return sid.String(), nil
+}
+
+// EncodeObjectSid encode the string form of an objectSid into its binary form, as stored in AD
+func EncodeObjectSid(sid string) ([]byte, error) {
+	parts := strings.Split(sid, "-")
+	if len(parts) < 3 || !strings.EqualFold(parts[0], "S") || parts[1] != strconv.Itoa(objectSidRevision) {
+		return nil, errors.Wrapf(ErrInvalidObjectSid, "'%s' is not in format S-1-<authority>-<sub-authorities>", sid)
+	}
+	subAuthorities := parts[3:]
+	if len(subAuthorities) > objectSidMaxSubAuthorities {
+		return nil, errors.Wrapf(ErrInvalidObjectSid, "'%s' has more than %d sub-authorities", sid, objectSidMaxSubAuthorities)
+	}
+	var authority uint64
+	var err error
+	if strings.HasPrefix(strings.ToLower(parts[2]), "0x") {
+		authority, err = strconv.ParseUint(parts[2][2:], 16, 48)
+	} else {
+		authority, err = strconv.ParseUint(parts[2], 10, 48)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidObjectSid, "'%s' has an invalid identifier authority", sid)
+	}
+	raw := make([]byte, objectSidHeaderLength+4*len(subAuthorities))
+	raw[0] = objectSidRevision
+	raw[1] = byte(len(subAuthorities))
+	for i := 0; i < 6; i++ {
+		raw[7-i] = byte(authority >> (8 * uint(i)))
+	}
+	for i, part := range subAuthorities {
+		subAuthority, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidObjectSid, "'%s' has an invalid sub-authority '%s'", sid, part)
+		}
+		offset := objectSidHeaderLength + 4*i
+		binary.LittleEndian.PutUint32(raw[offset:offset+4], uint32(subAuthority))
+	}
+	return raw, nil
+}
@@ -0,0 +1,247 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"testing"
+)
+
+// mustDecodeHex decode a test vector
+func mustDecodeHex(t *testing.T, s string) []byte {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestObjectGUIDVectors(t *testing.T) {
+	tests := []struct {
+		raw  string
+		guid string
+	}{
+		{"33221100554477668899aabbccddeeff", "00112233-4455-6677-8899-aabbccddeeff"},
+		{"e4a3c1d7429b0e4f8a1c3e5f7a9b2c4d", "d7c1a3e4-9b42-4f0e-8a1c-3e5f7a9b2c4d"},
+		{"00000000000000000000000000000000", "00000000-0000-0000-0000-000000000000"},
+	}
+	for _, test := range tests {
+		raw := mustDecodeHex(t, test.raw)
+		guid, err := DecodeObjectGUID(raw)
+		if err != nil {
+			t.Fatalf("%s: %s", test.raw, err)
+		}
+		if guid != test.guid {
+			t.Errorf("%s is decoded as %s instead of %s", test.raw, guid, test.guid)
+		}
+		encoded, err := EncodeObjectGUID(test.guid)
+		if err != nil {
+			t.Fatalf("%s: %s", test.guid, err)
+		}
+		if !bytes.Equal(encoded, raw) {
+			t.Errorf("%s is encoded as %x instead of %s", test.guid, encoded, test.raw)
+		}
+	}
+}
+
+func TestObjectGUIDRoundTrip(t *testing.T) {
+	for _, guid := range []string{"01234567-89ab-cdef-0123-456789abcdef", "ffffffff-ffff-ffff-ffff-ffffffffffff"} {
+		raw, err := EncodeObjectGUID(guid)
+		if err != nil {
+			t.Fatalf("%s: %s", guid, err)
+		}
+		decoded, err := DecodeObjectGUID(raw)
+		if err != nil {
+			t.Fatalf("%s: %s", guid, err)
+		}
+		if decoded != guid {
+			t.Errorf("%s is decoded back as %s", guid, decoded)
+		}
+	}
+	// the string form is lower case, the upper case GUIDs are accepted by the encoder
+	raw, err := EncodeObjectGUID("01234567-89AB-CDEF-0123-456789ABCDEF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, _ := DecodeObjectGUID(raw); decoded != "01234567-89ab-cdef-0123-456789abcdef" {
+		t.Errorf("an upper case GUID is decoded back as %s", decoded)
+	}
+}
+
+func TestObjectGUIDErrors(t *testing.T) {
+	decodeTests := []struct {
+		raw []byte
+		err error
+	}{
+		{nil, ErrMissingAttribute},
+		{[]byte{}, ErrMissingAttribute},
+		{make([]byte, 15), ErrInvalidObjectGUID},
+		{make([]byte, 17), ErrInvalidObjectGUID},
+		{[]byte("jdoe"), ErrInvalidObjectGUID},
+	}
+	for _, test := range decodeTests {
+		if _, err := DecodeObjectGUID(test.raw); !errors.Is(err, test.err) {
+			t.Errorf("%d bytes: got %v instead of %v", len(test.raw), err, test.err)
+		}
+	}
+	for _, guid := range []string{
+		"",
+		"00112233445566778899aabbccddeeff",
+		"0011223-34455-6677-8899-aabbccddeeff",
+		"00112233-4455-6677-8899-aabbccddeeff-00",
+		"0011223g-4455-6677-8899-aabbccddeeff",
+	} {
+		if _, err := EncodeObjectGUID(guid); !errors.Is(err, ErrInvalidObjectGUID) {
+			t.Errorf("'%s': got %v instead of %v", guid, err, ErrInvalidObjectGUID)
+		}
+	}
+}
+
+func TestObjectSidVectors(t *testing.T) {
+	tests := []struct {
+		raw string
+		sid string
+	}{
+		{"010500000000000515000000dcf4dc3b833d2b46828ba62800020000", "S-1-5-21-1004336348-1177238915-682003330-512"},
+		{"01020000000000052000000020020000", "S-1-5-32-544"},
+		{"010100000000000100000000", "S-1-1-0"},
+		{"0100000000000005", "S-1-5"},
+		{"0101010000000000ffffffff", "S-1-0x010000000000-4294967295"},
+	}
+	for _, test := range tests {
+		raw := mustDecodeHex(t, test.raw)
+		sid, err := DecodeObjectSid(raw)
+		if err != nil {
+			t.Fatalf("%s: %s", test.raw, err)
+		}
+		if sid != test.sid {
+			t.Errorf("%s is decoded as %s instead of %s", test.raw, sid, test.sid)
+		}
+		encoded, err := EncodeObjectSid(test.sid)
+		if err != nil {
+			t.Fatalf("%s: %s", test.sid, err)
+		}
+		if !bytes.Equal(encoded, raw) {
+			t.Errorf("%s is encoded as %x instead of %s", test.sid, encoded, test.raw)
+		}
+	}
+}
+
+func TestObjectSidRoundTrip(t *testing.T) {
+	for _, sid := range []string{
+		"S-1-5-18",
+		"S-1-5-21-3623811015-3361044348-30300820-1013",
+		"S-1-0xFFFFFFFFFFFF-1-2",
+		"S-1-15-1-2-3-4-5-6-7-8-9-10-11-12-13-14-15",
+	} {
+		raw, err := EncodeObjectSid(sid)
+		if err != nil {
+			t.Fatalf("%s: %s", sid, err)
+		}
+		decoded, err := DecodeObjectSid(raw)
+		if err != nil {
+			t.Fatalf("%s: %s", sid, err)
+		}
+		if decoded != sid {
+			t.Errorf("%s is decoded back as %s", sid, decoded)
+		}
+	}
+	// a hexadecimal authority which fits in 32 bits is written in decimal
+	raw, err := EncodeObjectSid("S-1-0x5-21")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, _ := DecodeObjectSid(raw); decoded != "S-1-5-21" {
+		t.Errorf("S-1-0x5-21 is decoded back as %s", decoded)
+	}
+}
+
+func TestObjectSidErrors(t *testing.T) {
+	sixteenSubAuthorities := append([]byte{1, 16, 0, 0, 0, 0, 0, 5}, make([]byte, 4*16)...)
+	decodeTests := []struct {
+		name string
+		raw  []byte
+		err  error
+	}{
+		{"no bytes", nil, ErrMissingAttribute},
+		{"short header", mustDecodeHex(t, "01050000000000"), ErrInvalidObjectSid},
+		{"unknown revision", mustDecodeHex(t, "02010000000000050000000000"), ErrInvalidObjectSid},
+		{"16 sub-authorities", sixteenSubAuthorities, ErrInvalidObjectSid},
+		{"missing sub-authority", mustDecodeHex(t, "010200000000000520000000"), ErrInvalidObjectSid},
+		{"extra bytes", mustDecodeHex(t, "0101000000000005200000002002"), ErrInvalidObjectSid},
+	}
+	for _, test := range decodeTests {
+		if _, err := DecodeObjectSid(test.raw); !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v instead of %v", test.name, err, test.err)
+		}
+	}
+	for _, sid := range []string{
+		"",
+		"S-1",
+		"X-1-5-21",
+		"S-2-5-21",
+		"S-1-five-21",
+		"S-1-0x1000000000000-21",
+		"S-1-281474976710656-21",
+		"S-1-5-21-4294967296",
+		"S-1-5-21--1",
+		"S-1-15-1-2-3-4-5-6-7-8-9-10-11-12-13-14-15-16",
+	} {
+		if _, err := EncodeObjectSid(sid); !errors.Is(err, ErrInvalidObjectSid) {
+			t.Errorf("'%s': got %v instead of %v", sid, err, ErrInvalidObjectSid)
+		}
+	}
+}
+
+func TestHandleElementMalformed(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	guid := mustDecodeHex(t, "33221100554477668899aabbccddeeff")
+	sid := mustDecodeHex(t, "01020000000000052000000020020000")
+	element, err := HandleElement(LdapEntity{DN: "CN=jdoe,DC=example,DC=com", Attributes: []KeyValue{
+		{"objectGUID": []string{string(guid)}},
+		{"objectSid": []string{string(sid)}},
+		{"sAMAccountName": []string{"jdoe"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if element.Attributes.ObjectGUID != "00112233-4455-6677-8899-aabbccddeeff" || element.Attributes.ObjectSid != "S-1-5-32-544" {
+		t.Errorf("the entry is read as %+v", element.Attributes)
+	}
+	tests := []struct {
+		name       string
+		attributes []KeyValue
+		err        error
+	}{
+		{"missing objectGUID", []KeyValue{{"sAMAccountName": []string{"jdoe"}}}, ErrMissingAttribute},
+		{"short objectGUID", []KeyValue{{"objectGUID": []string{string(guid[:15])}}}, ErrInvalidObjectGUID},
+		{"text objectGUID", []KeyValue{{"objectGUID": []string{"00112233-4455-6677-8899-aabbccddeeff"}}}, ErrInvalidObjectGUID},
+	}
+	for _, test := range tests {
+		_, err := HandleElement(LdapEntity{DN: "CN=jdoe,DC=example,DC=com", Attributes: test.attributes})
+		var attributeErr *LdapAttributeError
+		if !errors.As(err, &attributeErr) {
+			t.Errorf("%s: got %v instead of a LdapAttributeError", test.name, err)
+			continue
+		}
+		if attributeErr.DN != "CN=jdoe,DC=example,DC=com" || attributeErr.Attribute != LdapIdentityObjectGUID {
+			t.Errorf("%s: the error is about the attribute %s of %s", test.name, attributeErr.Attribute, attributeErr.DN)
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v instead of %v", test.name, err, test.err)
+		}
+	}
+	// a malformed objectSid is not the identity, it is ignored
+	element, err = HandleElement(LdapEntity{DN: "CN=jdoe,DC=example,DC=com", Attributes: []KeyValue{
+		{"objectGUID": []string{string(guid)}},
+		{"objectSid": []string{string(sid[:7])}},
+	}})
+	if err != nil {
+		t.Fatalf("a malformed objectSid failed the entry: %s", err)
+	}
+	if element.Attributes.ObjectSid != "" {
+		t.Errorf("the malformed objectSid is read as %s", element.Attributes.ObjectSid)
+	}
+}
@@ -0,0 +1,206 @@
+package lib
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"gopkg.in/ldap.v2"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the FUIDUser fields filled from the AD attributes, the keys of LDAP_ATTRIBUTE_MAPPING
+const (
+	LdapFieldDn     = "dn"
+	LdapFieldMail   = "mail"
+	LdapFieldUPN    = "upn"
+	LdapFieldGroups = "groups"
+)
+
+// LdapEntryDN is the pseudo-attribute of the DN of the entry, it is not read from the entry attributes
+const LdapEntryDN = "dn"
+
+// the transforms of the attribute values, applied in order
+const (
+	// LdapTransformFirst keep the first value
+	LdapTransformFirst = "first"
+	// LdapTransformJoin join the values with commas into one value
+	LdapTransformJoin = "join"
+	// LdapTransformCN replace the DN values by the value of their first RDN, CN=Sales,OU=Groups,DC=example,DC=local is Sales
+	LdapTransformCN = "cn"
+	// LdapTransformLower lower case the values
+	LdapTransformLower = "lower"
+)
+
+// LdapIdentityObjectGUID is the AD identity attribute, other directories use a text attribute such as entryUUID
+const LdapIdentityObjectGUID = "objectGUID"
+
+// ldapAttributeRule fill a FUIDUser field with the values of an attribute, written as attribute|transform|transform
+type ldapAttributeRule struct {
+	attribute  string
+	transforms []string
+}
+
+// LdapAttributeMapping map the LDAP attributes of the AD objects to the FUIDUser fields.
+// the identity attribute is the key of the users in FUID, the FUIDUser objectGUID
+type LdapAttributeMapping struct {
+	identity string
+	rules    map[string]*ldapAttributeRule
+}
+
+var (
+	ldapMappingOnce sync.Once
+	ldapMapping     *LdapAttributeMapping
+	ldapMappingErr  error
+)
+
+// GetLdapAttributeMapping return the attribute mapping of the process, read from LDAP_ATTRIBUTE_MAPPING and LDAP_IDENTITY_ATTRIBUTE
+func GetLdapAttributeMapping() (*LdapAttributeMapping, error) {
+	ldapMappingOnce.Do(func() {
+		ldapMapping, ldapMappingErr = NewLdapAttributeMappingFromConfig()
+	})
+	return ldapMapping, ldapMappingErr
+}
+
+// NewLdapAttributeMappingFromConfig read the attribute mapping, the fields missing from LDAP_ATTRIBUTE_MAPPING are not filled
+func NewLdapAttributeMappingFromConfig() (*LdapAttributeMapping, error) {
+	var config map[string]string
+	if err := viper.UnmarshalKey("LDAP_ATTRIBUTE_MAPPING", &config); err != nil {
+		return nil, errors.Wrap(err, "invalid LDAP_ATTRIBUTE_MAPPING")
+	}
+	return NewLdapAttributeMapping(viper.GetString("LDAP_IDENTITY_ATTRIBUTE"), config)
+}
+
+// NewLdapAttributeMapping create an attribute mapping, config map the field names to their attribute|transform rules
+func NewLdapAttributeMapping(identity string, config map[string]string) (*LdapAttributeMapping, error) {
+	if identity == "" {
+		identity = LdapIdentityObjectGUID
+	}
+	mapping := &LdapAttributeMapping{identity: identity, rules: make(map[string]*ldapAttributeRule)}
+	for field, value := range config {
+		field = strings.ToLower(strings.TrimSpace(field))
+		switch field {
+		case LdapFieldDn, LdapFieldMail, LdapFieldUPN, LdapFieldGroups:
+		default:
+			return nil, errors.Errorf("unknown field '%s' in LDAP_ATTRIBUTE_MAPPING, the fields are %s, %s, %s and %s",
+				field, LdapFieldDn, LdapFieldMail, LdapFieldUPN, LdapFieldGroups)
+		}
+		parts := strings.Split(value, "|")
+		rule := &ldapAttributeRule{attribute: strings.TrimSpace(parts[0])}
+		if rule.attribute == "" {
+			return nil, errors.Errorf("no attribute is mapped to the field '%s' in LDAP_ATTRIBUTE_MAPPING", field)
+		}
+		for _, transform := range parts[1:] {
+			transform = strings.ToLower(strings.TrimSpace(transform))
+			switch transform {
+			case LdapTransformFirst, LdapTransformJoin, LdapTransformCN, LdapTransformLower:
+			default:
+				return nil, errors.Errorf("unknown transform '%s' of the field '%s' in LDAP_ATTRIBUTE_MAPPING", transform, field)
+			}
+			rule.transforms = append(rule.transforms, transform)
+		}
+		mapping.rules[field] = rule
+	}
+	return mapping, nil
+}
+
+// Attributes return the attributes to read, requested with the identity and mapped attributes added
+func (m *LdapAttributeMapping) Attributes(requested []string) []string {
+	seen := make(map[string]bool)
+	var attributes []string
+	add := func(attribute string) {
+		if attribute == "" || strings.EqualFold(attribute, LdapEntryDN) || seen[strings.ToLower(attribute)] {
+			return
+		}
+		seen[strings.ToLower(attribute)] = true
+		attributes = append(attributes, attribute)
+	}
+	for _, attribute := range requested {
+		add(strings.TrimSpace(attribute))
+	}
+	add(m.identity)
+	fields := make([]string, 0, len(m.rules))
+	for field := range m.rules {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		add(m.rules[field].attribute)
+	}
+	return attributes
+}
+
+// IsObjectGUID report whether the identity attribute is the binary AD objectGUID
+func (m *LdapAttributeMapping) IsObjectGUID() bool {
+	return strings.EqualFold(m.identity, LdapIdentityObjectGUID)
+}
+
+// Identity return the identity attribute
+func (m *LdapAttributeMapping) Identity() string {
+	return m.identity
+}
+
+// Mapped report whether a FUIDUser field is mapped to an attribute
+func (m *LdapAttributeMapping) Mapped(field string) bool {
+	_, ok := m.rules[field]
+	return ok
+}
+
+// Values return the values of a field, nil when the field is not mapped or the attribute is missing
+func (m *LdapAttributeMapping) Values(field, dn string, attributes map[string][]string) []string {
+	rule, ok := m.rules[field]
+	if !ok {
+		return nil
+	}
+	values := attributes[strings.ToLower(rule.attribute)]
+	if strings.EqualFold(rule.attribute, LdapEntryDN) {
+		values = []string{dn}
+	}
+	for _, transform := range rule.transforms {
+		values = applyLdapTransform(transform, values)
+	}
+	return values
+}
+
+// Value return the first value of a field, empty when the field is not mapped or the attribute is missing
+func (m *LdapAttributeMapping) Value(field, dn string, attributes map[string][]string) string {
+	if values := m.Values(field, dn, attributes); len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// applyLdapTransform transform attribute values
+func applyLdapTransform(transform string, values []string) []string {
+	if len(values) == 0 {
+		return values
+	}
+	switch transform {
+	case LdapTransformFirst:
+		return values[:1]
+	case LdapTransformJoin:
+		return []string{strings.Join(values, ",")}
+	case LdapTransformCN:
+		names := make([]string, 0, len(values))
+		for _, value := range values {
+			names = append(names, dnName(value))
+		}
+		return names
+	case LdapTransformLower:
+		lower := make([]string, 0, len(values))
+		for _, value := range values {
+			lower = append(lower, strings.ToLower(value))
+		}
+		return lower
+	}
+	return values
+}
+
+// dnName return the value of the first RDN of a DN, a value which is not a DN is returned as it is
+func dnName(value string) string {
+	dn, err := ldap.ParseDN(value)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return value
+	}
+	return dn.RDNs[0].Attributes[0].Value
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"github.com/spf13/viper"
+	"strings"
+	"testing"
+)
+
+func TestLdapAttributeMappingValues(t *testing.T) {
+	dn := "CN=John Doe,OU=Users,DC=example,DC=com"
+	attributes := map[string][]string{
+		"mail":     {"John.Doe@example.com", "jdoe@example.com"},
+		"memberof": {"CN=Sales,OU=Groups,DC=example,DC=com", "CN=VPN Users,OU=Groups,DC=example,DC=com", "not a DN"},
+	}
+	tests := []struct {
+		name   string
+		rule   string
+		values string
+	}{
+		{"attribute values", "memberOf", "CN=Sales,OU=Groups,DC=example,DC=com;CN=VPN Users,OU=Groups,DC=example,DC=com;not a DN"},
+		{"attribute name case", "MemberOf", "CN=Sales,OU=Groups,DC=example,DC=com;CN=VPN Users,OU=Groups,DC=example,DC=com;not a DN"},
+		{"first", "mail|first", "John.Doe@example.com"},
+		{"join", "mail|join", "John.Doe@example.com,jdoe@example.com"},
+		{"cn", "memberOf|cn", "Sales;VPN Users;not a DN"},
+		{"lower", "mail|lower", "john.doe@example.com;jdoe@example.com"},
+		{"transforms in order", "memberOf|cn|lower|join", "sales,vpn users,not a dn"},
+		{"first then cn", "memberOf | FIRST | cn", "Sales"},
+		{"entry DN", "dn|cn", "John Doe"},
+		{"missing attribute", "department|first", ""},
+	}
+	for _, test := range tests {
+		mapping, err := NewLdapAttributeMapping("", map[string]string{LdapFieldGroups: test.rule})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if values := strings.Join(mapping.Values(LdapFieldGroups, dn, attributes), ";"); values != test.values {
+			t.Errorf("%s: got %s instead of %s", test.name, values, test.values)
+		}
+		if value := mapping.Value(LdapFieldGroups, dn, attributes); value != strings.Split(test.values, ";")[0] {
+			t.Errorf("%s: the first value is %s", test.name, value)
+		}
+	}
+	// the fields which are not mapped are not filled
+	mapping, err := NewLdapAttributeMapping("", map[string]string{LdapFieldMail: "mail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Mapped(LdapFieldDn) || mapping.Values(LdapFieldDn, dn, attributes) != nil || mapping.Value(LdapFieldDn, dn, attributes) != "" {
+		t.Error("a field which is not mapped is filled")
+	}
+}
+
+func TestLdapAttributeMappingIdentity(t *testing.T) {
+	tests := []struct {
+		identity   string
+		objectGUID bool
+		attributes string
+	}{
+		{"", true, "sAMAccountName,memberOf,objectGUID,mail"},
+		{"objectguid", true, "sAMAccountName,memberOf,objectguid,mail"},
+		{"entryUUID", false, "sAMAccountName,memberOf,entryUUID,mail"},
+	}
+	for _, test := range tests {
+		mapping, err := NewLdapAttributeMapping(test.identity, map[string]string{
+			LdapFieldDn:     "dn",
+			LdapFieldMail:   "mail|first",
+			LdapFieldGroups: "MEMBEROF|cn",
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", test.identity, err)
+		}
+		if mapping.IsObjectGUID() != test.objectGUID {
+			t.Errorf("%s: the identity is the objectGUID: %t", test.identity, mapping.IsObjectGUID())
+		}
+		// the requested attributes come first, the DN of the entry is not an attribute and the duplicates are removed
+		if attributes := strings.Join(mapping.Attributes([]string{"sAMAccountName", " memberOf", "dn", ""}), ","); attributes != test.attributes {
+			t.Errorf("%s: the attributes read are %s instead of %s", test.identity, attributes, test.attributes)
+		}
+	}
+}
+
+func TestNewLdapAttributeMappingFromConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	tests := []struct {
+		name    string
+		mapping interface{}
+		err     string
+	}{
+		{"unknown field", map[string]string{"phone": "telephoneNumber"}, "unknown field 'phone'"},
+		{"no attribute", map[string]string{LdapFieldMail: " |first"}, "no attribute is mapped to the field 'mail'"},
+		{"unknown transform", map[string]string{LdapFieldGroups: "memberOf|upper"}, "unknown transform 'upper'"},
+		{"not a map", []string{"mail"}, "invalid LDAP_ATTRIBUTE_MAPPING"},
+	}
+	for _, test := range tests {
+		viper.Set("LDAP_ATTRIBUTE_MAPPING", test.mapping)
+		if _, err := NewLdapAttributeMappingFromConfig(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v instead of %s", test.name, err, test.err)
+		}
+	}
+	// the field names are case insensitive
+	viper.Set("LDAP_ATTRIBUTE_MAPPING", map[string]string{" Mail ": "mail", "UPN": "userPrincipalName"})
+	viper.Set("LDAP_IDENTITY_ATTRIBUTE", "entryUUID")
+	mapping, err := NewLdapAttributeMappingFromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mapping.Mapped(LdapFieldMail) || !mapping.Mapped(LdapFieldUPN) || mapping.Identity() != "entryUUID" {
+		t.Errorf("the mapping is read as %+v", mapping)
+	}
+}
+
+func TestPostUserPrincipalName(t *testing.T) {
+	// the FUID API 1.0 does not carry the userPrincipalName, it is not sent
+	client := newFakeFUIDClient()
+	controller := &FUIDController{client: client, cache: NewFUIDUserCache(0, 0)}
+	element := &LdapElement{DN: "CN=jdoe,DC=example,DC=com", Attributes: Attributes{ObjectGUID: "guid-jdoe",
+		Mail: "jdoe@example.com", UserPrincipalName: "jdoe@example.com"}}
+	user, err := controller.PostUser(element, newTestSessionEvent("jdoe", AUTHENTICATED, "10.0.0.1").Session, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UserPrincipalName != "" || client.users["example\\jdoe"].UserPrincipalName != "" {
+		t.Errorf("the userPrincipalName %s is sent to the FUID API 1.0", user.UserPrincipalName)
+	}
+	if user.Mail != "jdoe@example.com" || user.Dn != "CN=jdoe,DC=example,DC=com" {
+		t.Errorf("the user is written as %+v", user)
+	}
+}
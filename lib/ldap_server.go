@@ -3,15 +3,18 @@ package lib
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"gopkg.in/ldap.v2"
 	"io/ioutil"
-	"log"
+	"os"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type KeyValue map[string]interface{}
@@ -27,11 +30,17 @@ type LdapElement struct {
 	Attributes Attributes
 }
 
+// Attributes are the attributes of an LDAP/AD entry, ObjectGUID holds the LDAP_IDENTITY_ATTRIBUTE and the
+// Dn, Mail, UserPrincipalName and MemberOf fields are filled by the LDAP_ATTRIBUTE_MAPPING
 type Attributes struct {
-	Cn             string   `json:"cn"`
-	MemberOf       []string `json:"memberOf"`
-	ObjectGUID     string   `json:"objectGUID"`
-	SAMAccountName string   `json:"sAMAccountName"`
+	Cn                string   `json:"cn"`
+	MemberOf          []string `json:"memberOf"`
+	ObjectGUID        string   `json:"objectGUID"`
+	ObjectSid         string   `json:"objectSid,omitempty"`
+	SAMAccountName    string   `json:"sAMAccountName"`
+	Dn                string   `json:"dn,omitempty"`
+	Mail              string   `json:"mail,omitempty"`
+	UserPrincipalName string   `json:"userPrincipalName,omitempty"`
 }
 
 func NewADConnector() (*ldap.Conn, error) {
@@ -138,77 +147,139 @@ func getFromLDAP(connect *ldap.Conn, LDAPBaseDN, LDAPFilter string, LDAPAttribut
 }
 
 func GetLdapElement(username string, ldapConnector *ldap.Conn) (*LdapElement, error) {
+	return GetLdapElementByFilter(username, fmt.Sprintf(viper.GetString("LDAP_FILTER"), username), ldapConnector)
+}
+
+// GetLdapElementByFilter read the single entry matching an LDAP filter, a LdapLookupError is returned when
+// no entry or several entries match, a LdapAttributeError when the entry cannot be read
+func GetLdapElementByFilter(username, filter string, ldapConnector *ldap.Conn) (*LdapElement, error) {
 	baseDn, err := generateLdapBaseDn()
 	if err != nil {
 		return nil, err
 	}
-	filter := fmt.Sprintf(viper.GetString("LDAP_FILTER"), username)
-	attributes := strings.Split(viper.GetString("LDAP_ATTRIBUTES"), ",")
-	LDAPElements, err := getFromLDAP(ldapConnector, baseDn, filter, attributes, uint32(viper.GetInt("LDAP_PAGES")))
+	mapping, err := GetLdapAttributeMapping()
 	if err != nil {
 		return nil, err
 	}
-	if len(LDAPElements) == 0 {
-		return nil, errors.Errorf("could not find use %s in LDAP database", username)
+	attributes := mapping.Attributes(strings.Split(viper.GetString("LDAP_ATTRIBUTES"), ","))
+	LDAPElements, err := getFromLDAP(ldapConnector, baseDn, filter, attributes, uint32(viper.GetInt("LDAP_PAGES")))
+	if err != nil {
+		return nil, err
 	}
-	if len(LDAPElements) > 1 {
-		return nil, errors.Errorf("multiple user with name: %s found in LDAP database", username)
-
+	if len(LDAPElements) != 1 {
+		return nil, &LdapLookupError{Username: username, Matches: len(LDAPElements)}
 	}
 	user, err := HandleElement(LDAPElements[0])
 	if err != nil {
+		// a malformed directory object is dead-lettered, the sessions of its account are skipped
+		var attributeErr *LdapAttributeError
+		if errors.As(err, &attributeErr) {
+			logrus.Errorf("the LDAP entry of %s is skipped: %s", username, err)
+			deadLetterLdapEntry(LDAPElements[0], err)
+		}
 		return nil, err
 	}
 	return user, nil
 }
 
+// HandleElement read an LDAP entry, its identity and the attributes of the LDAP_ATTRIBUTE_MAPPING.
+// a LdapAttributeError is returned when the identity is missing or cannot be decoded
 func HandleElement(element LdapEntity) (*LdapElement, error) {
+	mapping, err := GetLdapAttributeMapping()
+	if err != nil {
+		return nil, err
+	}
 	var ldapElement LdapElement
 	ldapElement.DN = element.DN
+	values := make(map[string][]string)
 	for _, maps := range element.Attributes {
 		for key, value := range maps {
+			attributeValues, _ := value.([]string)
+			values[strings.ToLower(key)] = attributeValues
 			switch key {
 			case "cn":
-				ldapElement.Attributes.Cn = value.([]string)[0]
+				ldapElement.Attributes.Cn = firstValue(attributeValues)
 			case "memberOf":
-				ldapElement.Attributes.MemberOf = value.([]string)
-			case "objectGUID":
-				s, r := uuid.Parse(fmt.Sprintf("%x", value.([]string)[0][:]))
-				if r != nil {
-					log.Fatal(r)
+				ldapElement.Attributes.MemberOf = attributeValues
+			case "objectSid":
+				sid, err := DecodeObjectSid([]byte(firstValue(attributeValues)))
+				if err != nil {
+					logrus.Warningf("the objectSid of the LDAP entry %s is ignored: %s", element.DN, err)
+					continue
 				}
-				ldapElement.Attributes.ObjectGUID = handleGUID(s.String())
+				ldapElement.Attributes.ObjectSid = sid
 			case "sAMAccountName":
-				ldapElement.Attributes.SAMAccountName = value.([]string)[0]
+				ldapElement.Attributes.SAMAccountName = firstValue(attributeValues)
 			}
 		}
 	}
+	identity := firstValue(values[strings.ToLower(mapping.Identity())])
+	if mapping.IsObjectGUID() {
+		identity, err = DecodeObjectGUID([]byte(identity))
+		if err != nil {
+			return nil, &LdapAttributeError{DN: element.DN, Attribute: mapping.Identity(), Err: err}
+		}
+	}
+	// other directories identify their entries with a text attribute such as entryUUID
+	if identity == "" {
+		return nil, &LdapAttributeError{DN: element.DN, Attribute: mapping.Identity(), Err: ErrMissingAttribute}
+	}
+	ldapElement.Attributes.ObjectGUID = identity
+	ldapElement.Attributes.Dn = mapping.Value(LdapFieldDn, element.DN, values)
+	ldapElement.Attributes.Mail = mapping.Value(LdapFieldMail, element.DN, values)
+	ldapElement.Attributes.UserPrincipalName = mapping.Value(LdapFieldUPN, element.DN, values)
+	if mapping.Mapped(LdapFieldGroups) {
+		ldapElement.Attributes.MemberOf = mapping.Values(LdapFieldGroups, element.DN, values)
+	}
 	return &ldapElement, nil
-
 }
 
-func handleGUID(guid string) string {
-	parts := strings.Split(guid, "-")
-	partOne := []int{6, 7, 4, 5, 2, 3, 0, 1}
-	partTwoPositions := []int{2, 3, 0, 1}
-	partThreePositions := []int{2, 3, 0, 1}
-	partOneFixed := swapPosition(partOne, parts[0])
-	partTwoFixed := swapPosition(partTwoPositions, parts[1])
-	partThreeFixed := swapPosition(partThreePositions, parts[2])
-	parts[0] = partOneFixed
-	parts[1] = partTwoFixed
-	parts[2] = partThreeFixed
-	return strings.Join(parts, "-")
-
+// firstValue return the first value of an attribute, empty when the attribute has no value
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
 }
 
-func swapPosition(positions []int, data string) string {
-	newArr := make([]rune, 0, len(positions))
-	r := []rune(data)
-	for _, i := range positions {
-		newArr = append(newArr, r[i])
+// deadLetterLdapEntry append an LDAP entry which cannot be read to the LDAP_DEAD_LETTER_PATH file, as a JSON line.
+// the values which are not text, such as the binary objectGUID, are written in hexadecimal
+func deadLetterLdapEntry(element LdapEntity, reason error) {
+	path := viper.GetString("LDAP_DEAD_LETTER_PATH")
+	if path == "" {
+		return
+	}
+	attributes := make(map[string][]string)
+	for _, maps := range element.Attributes {
+		for key, value := range maps {
+			attributeValues, _ := value.([]string)
+			for _, attributeValue := range attributeValues {
+				if !utf8.ValidString(attributeValue) {
+					attributeValue = "hex:" + hex.EncodeToString([]byte(attributeValue))
+				}
+				attributes[key] = append(attributes[key], attributeValue)
+			}
+		}
+	}
+	line, err := json.Marshal(map[string]interface{}{
+		"time":       time.Now().UTC().Format(time.RFC3339),
+		"dn":         element.DN,
+		"error":      reason.Error(),
+		"attributes": attributes,
+	})
+	if err != nil {
+		logrus.Warningf("cannot write the LDAP entry %s to the dead letter file: %s", element.DN, err)
+		return
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logrus.Warningf("cannot write the LDAP entry %s to the dead letter file: %s", element.DN, err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		logrus.Warningf("cannot write the LDAP entry %s to the dead letter file: %s", element.DN, err)
 	}
-	return string(newArr)
 }
 
 func generateLdapUserDn(username string) (string, error) {
@@ -0,0 +1,263 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrLeadershipLost is returned when the HA leadership is lost during a poll, the poll is aborted before its checkpoint
+var ErrLeadershipLost = errors.New("the HA leadership was lost")
+
+// LeaseStore keeps the leadership lease shared by the instances of an HA pair
+type LeaseStore interface {
+	// Acquire take the lease for holder, or renew it when holder already owns it.
+	// true is returned when holder owns the lease for the next ttl
+	Acquire(holder string, ttl time.Duration) (bool, error)
+	// Release give the lease up when holder owns it
+	Release(holder string) error
+}
+
+// Lease is the content of a lease
+type Lease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// FileLeaseStore keeps the lease in a JSON file on a storage shared by the instances.
+// the lease file is updated under a lock file created exclusively next to it
+type FileLeaseStore struct {
+	path     string
+	lockPath string
+}
+
+// NewFileLeaseStore create a lease store on a lease file path
+func NewFileLeaseStore(path string) *FileLeaseStore {
+	return &FileLeaseStore{path: path, lockPath: path + ".lock"}
+}
+
+func (f *FileLeaseStore) Acquire(holder string, ttl time.Duration) (bool, error) {
+	acquired := false
+	err := f.withLock(ttl, func() error {
+		lease, err := f.read()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if lease != nil && lease.Holder != holder && now.Before(lease.Expires) {
+			return nil
+		}
+		acquired = true
+		return f.write(&Lease{Holder: holder, Expires: now.Add(ttl)})
+	})
+	return acquired, err
+}
+
+func (f *FileLeaseStore) Release(holder string) error {
+	return f.withLock(time.Minute, func() error {
+		lease, err := f.read()
+		if err != nil || lease == nil || lease.Holder != holder {
+			return err
+		}
+		return os.Remove(f.path)
+	})
+}
+
+// withLock run fn while holding the lock file. a lock file older than staleAfter is left by a crashed instance,
+// it is moved aside with an atomic rename so only one instance takes it over
+func (f *FileLeaseStore) withLock(staleAfter time.Duration, fn func() error) error {
+	token := fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+	err := f.createLock(token)
+	if os.IsExist(err) && f.takeOverStaleLock(token, staleAfter) {
+		err = f.createLock(token)
+	}
+	if os.IsExist(err) {
+		return errors.Errorf("the lease lock %s is held by another instance", f.lockPath)
+	}
+	if err != nil {
+		return errors.Wrap(err, "cannot create the lease lock")
+	}
+	defer f.releaseLock(token)
+	// the lock is read back, a stale lock takeover racing with its creation would have moved it
+	if owner, err := ioutil.ReadFile(f.lockPath); err != nil || string(owner) != token {
+		return errors.Errorf("the lease lock %s was taken over by another instance", f.lockPath)
+	}
+	return fn()
+}
+
+// createLock create the lock file exclusively with the token of its owner
+func (f *FileLeaseStore) createLock(token string) error {
+	lockFile, err := os.OpenFile(f.lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = lockFile.WriteString(token)
+	if closeErr := lockFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.lockPath)
+	}
+	return err
+}
+
+// releaseLock remove the lock file when it is still owned by token
+func (f *FileLeaseStore) releaseLock(token string) {
+	if owner, err := ioutil.ReadFile(f.lockPath); err == nil && string(owner) == token {
+		_ = os.Remove(f.lockPath)
+	}
+}
+
+// takeOverStaleLock move a stale lock file aside, true is returned when the lock can be created again.
+// a lock created by another instance after the staleness check is put back
+func (f *FileLeaseStore) takeOverStaleLock(token string, staleAfter time.Duration) bool {
+	if info, err := os.Stat(f.lockPath); err != nil || time.Since(info.ModTime()) <= staleAfter {
+		return false
+	}
+	aside := fmt.Sprintf("%s.%s.stale", f.lockPath, token)
+	if err := os.Rename(f.lockPath, aside); err != nil {
+		// another instance moved it first
+		return false
+	}
+	defer os.Remove(aside)
+	if info, err := os.Stat(aside); err != nil || time.Since(info.ModTime()) <= staleAfter {
+		_ = os.Link(aside, f.lockPath)
+		return false
+	}
+	logrus.Warningf("the stale lease lock %s left by a stopped instance is taken over", f.lockPath)
+	return true
+}
+
+func (f *FileLeaseStore) read() (*Lease, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		// a corrupted lease is taken over
+		logrus.Warningf("the lease file %s is invalid: %s", f.path, err)
+		return nil, nil
+	}
+	return &lease, nil
+}
+
+// write replace the lease file atomically
+func (f *FileLeaseStore) write(lease *Lease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), f.path)
+}
+
+// LeaderElector keep an instance elected as long as it renews its lease.
+// the lease is renewed every third of the lease time, a standby takes over at most one lease time after the leader stopped
+type LeaderElector struct {
+	mutex       sync.Mutex
+	store       LeaseStore
+	holder      string
+	leaseTime   time.Duration
+	leaderUntil time.Time
+}
+
+// NewLeaderElector create an elector for holder
+func NewLeaderElector(store LeaseStore, holder string, leaseTime time.Duration) *LeaderElector {
+	return &LeaderElector{store: store, holder: holder, leaseTime: leaseTime}
+}
+
+// NewLeaderElectorFromConfig create an elector from the HA_* configs, nil is returned when HA_ENABLED is false
+func NewLeaderElectorFromConfig() (*LeaderElector, error) {
+	if !viper.GetBool("HA_ENABLED") {
+		return nil, nil
+	}
+	if viper.GetString("HA_LEASE_PATH") == "" {
+		return nil, errors.New("the HA lease file HA_LEASE_PATH is not provided")
+	}
+	leaseTime := time.Duration(viper.GetInt("HA_LEASE_TIME")) * time.Second
+	if leaseTime < 3*time.Second {
+		return nil, errors.Errorf("invalid HA_LEASE_TIME %d, the lease time must be at least 3 seconds", viper.GetInt("HA_LEASE_TIME"))
+	}
+	holder := viper.GetString("HA_NODE_ID")
+	if holder == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		holder = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	return NewLeaderElector(NewFileLeaseStore(viper.GetString("HA_LEASE_PATH")), holder, leaseTime), nil
+}
+
+// Start renew the lease in the background, the lease is released when the process exits through logrus
+func (l *LeaderElector) Start() {
+	l.renew()
+	go func() {
+		for range time.Tick(l.leaseTime / 3) {
+			l.renew()
+		}
+	}()
+	logrus.RegisterExitHandler(func() {
+		if err := l.store.Release(l.holder); err != nil {
+			logrus.Warningf("cannot release the HA lease: %s", err)
+		}
+	})
+}
+
+// IsLeader report whether the lease is owned, the leadership is lost when the lease could not be renewed in time.
+// a nil elector is the leader, the HA mode is disabled
+func (l *LeaderElector) IsLeader() bool {
+	if l == nil {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return time.Now().Before(l.leaderUntil)
+}
+
+func (l *LeaderElector) renew() {
+	wasLeader := l.IsLeader()
+	start := time.Now()
+	acquired, err := l.store.Acquire(l.holder, l.leaseTime)
+	if err != nil {
+		logrus.Warningf("cannot renew the HA lease: %s", err)
+		return
+	}
+	l.mutex.Lock()
+	if acquired {
+		// the lease started before the store call, the local deadline must not outlive it
+		l.leaderUntil = start.Add(l.leaseTime)
+	} else {
+		l.leaderUntil = time.Time{}
+	}
+	l.mutex.Unlock()
+	if acquired && !wasLeader {
+		logrus.Infof("instance %s is the HA leader", l.holder)
+	}
+	if !acquired && wasLeader {
+		logrus.Warningf("instance %s lost the HA leadership", l.holder)
+	}
+}
@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"math"
+	"sync"
+	"time"
+)
+
+// the limited targets, each limiter reads its settings from the config with the limiter name as prefix
+const (
+	LimiterFUID = "FUID"
+	LimiterLDAP = "LDAP"
+	LimiterISE  = "ISE"
+)
+
+// the HTTP client targets sharing a limiter
+var targetLimiters = map[string]string{
+	TargetISEControl: LimiterISE,
+	TargetISESession: LimiterISE,
+	TargetFUID:       LimiterFUID,
+}
+
+// the interval between two warnings of a throttling limiter
+const limiterWarningInterval = 30 * time.Second
+
+var (
+	limitersMutex sync.Mutex
+	limiters      = make(map[string]*Limiter)
+)
+
+func init() {
+	DefaultMetrics.Describe("fuid_ise_limiter_requests_total", MetricCounter, "requests which went through a limiter")
+	DefaultMetrics.Describe("fuid_ise_limiter_throttled_total", MetricCounter, "requests delayed by a limiter")
+	DefaultMetrics.Describe("fuid_ise_limiter_wait_seconds_total", MetricCounter, "time the requests waited for a limiter")
+	DefaultMetrics.Describe("fuid_ise_limiter_in_flight", MetricGauge, "requests in flight")
+	DefaultMetrics.Describe("fuid_ise_limiter_rate_limit", MetricGauge, "configured requests per second, 0 is unlimited")
+	DefaultMetrics.Describe("fuid_ise_limiter_burst", MetricGauge, "configured token bucket size")
+	DefaultMetrics.Describe("fuid_ise_limiter_max_in_flight", MetricGauge, "configured maximum of requests in flight, 0 is unlimited")
+}
+
+// Limiter bound the request rate to a target with a token bucket, and the requests in flight with a semaphore.
+// the callers wait for a token and a slot, so a burst of sessions slows the session processing down instead of being dropped
+type Limiter struct {
+	name        string
+	rate        float64
+	burst       int
+	maxInFlight int
+	slots       chan struct{}
+	mutex       sync.Mutex
+	tokens      float64
+	last        time.Time
+	lastWarning time.Time
+}
+
+// NewLimiter create a limiter, a rate or a maxInFlight of zero disables the limit. the burst is at least one token
+func NewLimiter(name string, rate float64, burst, maxInFlight int) *Limiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	limiter := &Limiter{name: name, rate: rate, burst: burst, maxInFlight: maxInFlight, tokens: float64(burst), last: time.Now()}
+	if maxInFlight > 0 {
+		limiter.slots = make(chan struct{}, maxInFlight)
+	}
+	labels := Labels{"limiter": name}
+	DefaultMetrics.Set("fuid_ise_limiter_rate_limit", labels, rate)
+	DefaultMetrics.Set("fuid_ise_limiter_burst", labels, float64(burst))
+	DefaultMetrics.Set("fuid_ise_limiter_max_in_flight", labels, float64(maxInFlight))
+	return limiter
+}
+
+// GetLimiter return the limiter of a name, created from the <NAME>_RATE_LIMIT, <NAME>_RATE_BURST and <NAME>_MAX_IN_FLIGHT configs
+func GetLimiter(name string) *Limiter {
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+	limiter, ok := limiters[name]
+	if !ok {
+		limiter = NewLimiter(name, viper.GetFloat64(name+"_RATE_LIMIT"), viper.GetInt(name+"_RATE_BURST"), viper.GetInt(name+"_MAX_IN_FLIGHT"))
+		if limiter.rate > 0 || limiter.maxInFlight > 0 {
+			logrus.Infof("%s requests are limited to %s", name, limiter)
+		}
+		limiters[name] = limiter
+	}
+	return limiter
+}
+
+func (l *Limiter) String() string {
+	rate, inFlight := "unlimited rate", "unlimited requests in flight"
+	if l.rate > 0 {
+		rate = fmt.Sprintf("%g requests per second with bursts of %d", l.rate, l.burst)
+	}
+	if l.maxInFlight > 0 {
+		inFlight = fmt.Sprintf("%d requests in flight", l.maxInFlight)
+	}
+	return rate + " and " + inFlight
+}
+
+// Acquire wait for a slot and a token, release must be called once the request is completed.
+// a nil limiter does not limit
+func (l *Limiter) Acquire() (release func()) {
+	if l == nil {
+		return func() {}
+	}
+	start := time.Now()
+	if l.slots != nil {
+		l.slots <- struct{}{}
+	}
+	l.takeToken()
+	waited := time.Since(start)
+	labels := Labels{"limiter": l.name}
+	DefaultMetrics.Add("fuid_ise_limiter_requests_total", labels, 1)
+	DefaultMetrics.Add("fuid_ise_limiter_in_flight", labels, 1)
+	if waited > time.Millisecond {
+		DefaultMetrics.Add("fuid_ise_limiter_throttled_total", labels, 1)
+		DefaultMetrics.Add("fuid_ise_limiter_wait_seconds_total", labels, waited.Seconds())
+		l.warn(waited)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			DefaultMetrics.Add("fuid_ise_limiter_in_flight", labels, -1)
+			if l.slots != nil {
+				<-l.slots
+			}
+		})
+	}
+}
+
+// takeToken wait for a token of the bucket
+func (l *Limiter) takeToken() {
+	if l.rate <= 0 {
+		return
+	}
+	for {
+		l.mutex.Lock()
+		now := time.Now()
+		l.tokens = math.Min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mutex.Unlock()
+			return
+		}
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mutex.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// warn log a throttling limiter, at most once per limiterWarningInterval
+func (l *Limiter) warn(waited time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if time.Since(l.lastWarning) < limiterWarningInterval {
+		return
+	}
+	l.lastWarning = time.Now()
+	logrus.Warningf("%s requests are throttled, a request waited %s, the limits are %s", l.name, waited.Round(time.Millisecond), l)
+}
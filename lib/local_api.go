@@ -0,0 +1,236 @@
+package lib
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	LocalApiMappingsIP   = "/api/v1/mappings/ip/"
+	LocalApiMappingsUser = "/api/v1/mappings/user/"
+	LocalApiMappingsMac  = "/api/v1/mappings/mac/"
+	LocalApiSessions     = "/api/v1/sessions"
+	LocalApiMetrics      = "/metrics"
+	LocalApiLdapCache    = "/api/v1/ldap-cache/flush"
+)
+
+// LocalAPI serve the mapping index over an authenticated local HTTP API.
+// every request must carry the LOCAL_API_TOKEN as a bearer token, the actions changing the network access of the
+// endpoints carry the LOCAL_API_ANC_TOKEN instead
+type LocalAPI struct {
+	index    *MappingIndex
+	token    string
+	ancToken string
+	address  string
+	certPath string
+	keyPath  string
+	mux      *http.ServeMux
+}
+
+// NewLocalAPI create the local API from the LOCAL_API_* configs
+func NewLocalAPI(index *MappingIndex) (*LocalAPI, error) {
+	api := &LocalAPI{
+		index:    index,
+		token:    viper.GetString("LOCAL_API_TOKEN"),
+		ancToken: viper.GetString("LOCAL_API_ANC_TOKEN"),
+		address:  viper.GetString("LOCAL_API_ADDRESS"),
+		certPath: viper.GetString("LOCAL_API_TLS_CERT_PATH"),
+		keyPath:  viper.GetString("LOCAL_API_TLS_KEY_PATH"),
+		mux:      http.NewServeMux(),
+	}
+	if api.token == "" {
+		return nil, errors.New("the local API token LOCAL_API_TOKEN is not provided")
+	}
+	if api.ancToken != "" && api.ancToken == api.token {
+		return nil, errors.New("the local API ANC token LOCAL_API_ANC_TOKEN must differ from LOCAL_API_TOKEN")
+	}
+	if api.address == "" {
+		return nil, errors.New("the local API address LOCAL_API_ADDRESS is not provided")
+	}
+	api.HandleFunc(LocalApiMappingsIP, http.MethodGet, api.lookupIP)
+	api.HandleFunc(LocalApiMappingsUser, http.MethodGet, api.lookupUser)
+	api.HandleFunc(LocalApiMappingsMac, http.MethodGet, api.lookupMac)
+	api.HandleFunc(LocalApiSessions, http.MethodGet, api.sessions)
+	api.HandleFunc(LocalApiMetrics, http.MethodGet, api.metrics)
+	api.HandleFunc(LocalApiLdapCache, http.MethodPost, api.flushLdapCache)
+	return api, nil
+}
+
+// HandleFunc register an authenticated handler for a path and a method
+func (a *LocalAPI) HandleFunc(pattern, method string, handler http.HandlerFunc) {
+	a.handle(pattern, method, a.token, handler)
+}
+
+// HandleANCFunc register a handler changing the network access of the endpoints, it is authorized by the
+// LOCAL_API_ANC_TOKEN only. an error is returned when the LOCAL_API_ANC_TOKEN is not provided
+func (a *LocalAPI) HandleANCFunc(pattern, method string, handler http.HandlerFunc) error {
+	if a.ancToken == "" {
+		return errors.New("the local API ANC token LOCAL_API_ANC_TOKEN is not provided")
+	}
+	a.handle(pattern, method, a.ancToken, handler)
+	return nil
+}
+
+// handle register a handler authorized by a bearer token
+func (a *LocalAPI) handle(pattern, method, token string, handler http.HandlerFunc) {
+	a.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fuid-ise"`)
+			writeJSONError(w, http.StatusUnauthorized, "not authorized")
+			return
+		}
+		if r.Method != method {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// Start listen in the background, TLS is used when a certificate and key are configured
+func (a *LocalAPI) Start() error {
+	listener, err := net.Listen("tcp", a.address)
+	if err != nil {
+		return errors.Wrapf(err, "cannot listen on the local API address %s", a.address)
+	}
+	server := &http.Server{Handler: a.mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		var err error
+		if a.certPath != "" {
+			err = server.ServeTLS(listener, a.certPath, a.keyPath)
+		} else {
+			err = server.Serve(listener)
+		}
+		logrus.Errorf("local API stopped: %s", err)
+	}()
+	logrus.Infof("local API is listening on %s", a.address)
+	return nil
+}
+
+// authorized report whether a request carries token as a bearer token
+func authorized(r *http.Request, token string) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) == 1
+}
+
+func (a *LocalAPI) lookupIP(w http.ResponseWriter, r *http.Request) {
+	ip := pathValue(r, LocalApiMappingsIP)
+	if net.ParseIP(ip) == nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid IP address")
+		return
+	}
+	mapping, ok := a.index.LookupIP(ip)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no user is mapped to "+ip)
+		return
+	}
+	writeJSON(w, http.StatusOK, mapping)
+}
+
+func (a *LocalAPI) lookupUser(w http.ResponseWriter, r *http.Request) {
+	user := pathValue(r, LocalApiMappingsUser)
+	mappings := a.index.LookupUser(user)
+	if len(mappings) == 0 {
+		writeJSONError(w, http.StatusNotFound, "no session for user "+user)
+		return
+	}
+	var ipAddresses []string
+	for _, mapping := range mappings {
+		ipAddresses = append(ipAddresses, mapping.IpAddresses...)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"user": user, "ipAddresses": ipAddresses, "sessions": mappings})
+}
+
+func (a *LocalAPI) lookupMac(w http.ResponseWriter, r *http.Request) {
+	mac := pathValue(r, LocalApiMappingsMac)
+	mapping, ok := a.index.LookupMac(mac)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no session for MAC address "+mac)
+		return
+	}
+	writeJSON(w, http.StatusOK, mapping)
+}
+
+func (a *LocalAPI) sessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": a.index.Sessions()})
+}
+
+// metrics serve the metrics registry in the Prometheus text format
+func (a *LocalAPI) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := DefaultMetrics.WriteText(w); err != nil {
+		logrus.Warningf("cannot write the metrics: %s", err)
+	}
+}
+
+// flushLdapCache remove the cached AD lookups, so the next sessions search their accounts in AD again
+func (a *LocalAPI) flushLdapCache(w http.ResponseWriter, r *http.Request) {
+	flushed := GetLdapCache().Flush()
+	logrus.Infof("the LDAP cache is flushed, %d lookups removed", flushed)
+	writeJSON(w, http.StatusOK, map[string]int{"flushed": flushed})
+}
+
+// LocalAPIRequest send a request to the local API of the running consumer, with the LOCAL_API_* configs.
+// the local API TLS certificate is trusted when it is configured
+func LocalAPIRequest(method, path string) (*http.Response, error) {
+	address := viper.GetString("LOCAL_API_ADDRESS")
+	if address == "" {
+		return nil, errors.New("the local API address LOCAL_API_ADDRESS is not provided")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	scheme := "http"
+	if certPath := viper.GetString("LOCAL_API_TLS_CERT_PATH"); certPath != "" {
+		certificate, err := ioutil.ReadFile(certPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read the local API certificate")
+		}
+		rootCAs := x509.NewCertPool()
+		rootCAs.AppendCertsFromPEM(certificate)
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs}}
+		scheme = "https"
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s://%s%s", scheme, address, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+viper.GetString("LOCAL_API_TOKEN"))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot reach the local API, is the consumer running with LOCAL_API_ENABLED")
+	}
+	return resp, nil
+}
+
+// pathValue return the unescaped rest of the path after prefix
+func pathValue(r *http.Request, prefix string) string {
+	value := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"error": message})
+}
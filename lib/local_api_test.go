@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestLocalAPI create a local API with the read and the ANC tokens
+func newTestLocalAPI(t *testing.T, token, ancToken string) (*LocalAPI, error) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("LOCAL_API_ADDRESS", "127.0.0.1:0")
+	viper.Set("LOCAL_API_TOKEN", token)
+	viper.Set("LOCAL_API_ANC_TOKEN", ancToken)
+	return NewLocalAPI(NewMappingIndex(0))
+}
+
+func TestLocalAPIANCToken(t *testing.T) {
+	api, err := newTestLocalAPI(t, "read", "anc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.HandleANCFunc(LocalApiANCApply, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		method string
+		token  string
+		status int
+	}{
+		{LocalApiSessions, http.MethodGet, "read", http.StatusOK},
+		{LocalApiSessions, http.MethodGet, "anc", http.StatusUnauthorized},
+		{LocalApiANCApply, http.MethodPost, "anc", http.StatusOK},
+		{LocalApiANCApply, http.MethodPost, "read", http.StatusUnauthorized},
+		{LocalApiANCApply, http.MethodPost, "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		recorder := httptest.NewRecorder()
+		api.mux.ServeHTTP(recorder, req)
+		if recorder.Code != test.status {
+			t.Errorf("%s %s with the token '%s' got status %d instead of %d", test.method, test.path, test.token, recorder.Code, test.status)
+		}
+	}
+}
+
+func TestLocalAPIANCTokenConfig(t *testing.T) {
+	api, err := newTestLocalAPI(t, "read", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.HandleANCFunc(LocalApiANCApply, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {}); err == nil {
+		t.Error("the ANC routes are served without LOCAL_API_ANC_TOKEN")
+	}
+	if _, err := newTestLocalAPI(t, "same", "same"); err == nil {
+		t.Error("the ANC token is accepted when it is the read token")
+	}
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+// the IDENTITY_PRECEDENCE values, they decide which identity keeps an IP address used by a user and a machine
+const (
+	PrecedenceUser    = "user"
+	PrecedenceMachine = "machine"
+	PrecedenceLatest  = "latest"
+	machineHostPrefix = "host/"
+)
+
+// SessionAccount is the AD account of a session, a user or a computer account
+type SessionAccount struct {
+	NetBiosName    string
+	SAMAccountName string
+	Machine        bool
+}
+
+// NTLMIdentity return the account identity in format DOMAIN\sAMAccountName
+func (a *SessionAccount) NTLMIdentity() string {
+	return fmt.Sprintf("%s\\%s", a.NetBiosName, a.SAMAccountName)
+}
+
+// isMachineSession report whether a session is a machine authentication, the ISE flag or a host/ username
+func isMachineSession(sess *Sessions) bool {
+	if !viper.GetBool("MACHINE_AUTH_ENABLED") {
+		return false
+	}
+	return strings.EqualFold(sess.IsMachineAuthentication, "true") ||
+		strings.HasPrefix(strings.ToLower(sess.Username), machineHostPrefix)
+}
+
+// sessionAccount resolve the AD account of a session, machine sessions are mapped to their computer account PC01$.
+// AD_NETBIOS_NAME is used when ISE did not resolve the domain of a machine
+func sessionAccount(sess *Sessions) (*SessionAccount, error) {
+	if isMachineSession(sess) {
+		account := &SessionAccount{NetBiosName: sess.AdUserNetBiosName, SAMAccountName: machineAccountName(sess), Machine: true}
+		if account.NetBiosName == "" {
+			account.NetBiosName = viper.GetString("AD_NETBIOS_NAME")
+		}
+		if account.SAMAccountName == "$" || account.NetBiosName == "" {
+			return nil, errors.Errorf("Machine %s is not avaiable in Active Directory, set AD_NETBIOS_NAME in the config file to resolve the machine sessions", sess.Username)
+		}
+		return account, nil
+	}
+	userAccountName := sess.AdUserSamAccountName
+	if userAccountName == "" {
+		userAccountName = sess.AdNormalizedUser
+	}
+	if userAccountName == "" {
+		if sess.Username == "" {
+			return nil, errors.Errorf("User %s is not avaiable in Active Directory, set IGNORE_UNKNOWN_SESSIONS in the config file to true to ingone unknown sessions", sess.Username)
+		}
+		usernameParts := strings.Split(sess.Username, "@")
+		userAccountName = usernameParts[0]
+	}
+	if sess.AdUserNetBiosName == "" {
+		return nil, errors.Errorf("User %s is not avaiable in Active Directory, set IGNORE_UNKNOWN_SESSIONS in the config file to true to ingone unknown sessions", sess.Username)
+	}
+	return &SessionAccount{NetBiosName: sess.AdUserNetBiosName, SAMAccountName: userAccountName}, nil
+}
+
+// machineAccountName turn host/PC01.example.local or PC01$ into the computer account name PC01$
+func machineAccountName(sess *Sessions) string {
+	if strings.HasSuffix(sess.AdUserSamAccountName, "$") {
+		return sess.AdUserSamAccountName
+	}
+	name := sess.Username
+	if strings.HasPrefix(strings.ToLower(name), machineHostPrefix) {
+		name = name[len(machineHostPrefix):]
+	}
+	if i := strings.IndexAny(name, ".@"); i != -1 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, "\\"); i != -1 {
+		name = name[i+1:]
+	}
+	return strings.ToUpper(strings.TrimSuffix(name, "$")) + "$"
+}
+
+// ldapAccountFilter return the LDAP filter of an account, computer accounts use MACHINE_LDAP_FILTER
+func ldapAccountFilter(account *SessionAccount) string {
+	if account.Machine {
+		return fmt.Sprintf(viper.GetString("MACHINE_LDAP_FILTER"), account.SAMAccountName)
+	}
+	return fmt.Sprintf(viper.GetString("LDAP_FILTER"), account.SAMAccountName)
+}
+
+// validatePrecedence check an IDENTITY_PRECEDENCE value
+func validatePrecedence(precedence string) (string, error) {
+	precedence = strings.ToLower(precedence)
+	switch precedence {
+	case "":
+		return PrecedenceLatest, nil
+	case PrecedenceUser, PrecedenceMachine, PrecedenceLatest:
+		return precedence, nil
+	}
+	return "", errors.Errorf("unsupported IDENTITY_PRECEDENCE '%s', supported values are user, machine and latest", precedence)
+}
+
+// Overridden return the session of the other identity kind holding an IP address of the event when that kind has precedence.
+// such a login is not delivered, so the IP address stays mapped to the identity with precedence. the logouts are never overridden
+func (m *MappingIndex) Overridden(event *IdentityEvent, precedence string) (*Mapping, bool) {
+	machine := isMachineSession(event.Session)
+	if event.Session.State == DISCONNECTED || precedence == PrecedenceLatest || (precedence == PrecedenceUser) != machine {
+		return nil, false
+	}
+	for _, ip := range event.Session.IpAddresses {
+		mapping, ok := m.LookupIP(ip)
+		if ok && mapping.Machine != machine {
+			return mapping, true
+		}
+	}
+	return nil, false
+}
+
+// Released return the logouts of the sessions of the other identity kind holding IP addresses of a login.
+// the login takes the IP addresses over, the logouts delete them from the identities which lost them
+func (m *MappingIndex) Released(event *IdentityEvent) []*IdentityEvent {
+	if event.Session.State == DISCONNECTED {
+		return nil
+	}
+	machine := isMachineSession(event.Session)
+	var released []*IdentityEvent
+	losers := make(map[string]*IdentityEvent)
+	for _, ip := range event.Session.IpAddresses {
+		mapping, ok := m.LookupIP(ip)
+		if !ok || mapping.Machine == machine {
+			continue
+		}
+		if logout, ok := losers[strings.ToLower(mapping.User)]; ok {
+			logout.Session.IpAddresses = append(logout.Session.IpAddresses, ip)
+			continue
+		}
+		logout := &IdentityEvent{Session: releasedSession(mapping, ip, event.Session.Timestamp), Source: event.Source}
+		losers[strings.ToLower(mapping.User)] = logout
+		released = append(released, logout)
+	}
+	return released
+}
+
+// releasedSession build the logout session of the identity of a mapping for an IP address
+func releasedSession(mapping *Mapping, ip string, timestamp *time.Time) *Sessions {
+	sess := &Sessions{
+		Timestamp:            timestamp,
+		State:                DISCONNECTED,
+		Username:             mapping.User,
+		IpAddresses:          []string{ip},
+		MacAddress:           mapping.MacAddress,
+		AdUserSamAccountName: mapping.User,
+	}
+	if i := strings.Index(mapping.User, "\\"); i != -1 {
+		sess.AdUserNetBiosName = mapping.User[:i]
+		sess.AdUserSamAccountName = mapping.User[i+1:]
+		sess.Username = sess.AdUserSamAccountName
+	}
+	if mapping.Machine {
+		sess.IsMachineAuthentication = "true"
+	}
+	return sess
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"strings"
+	"testing"
+)
+
+// recordingSink record the events it receives as "action user ips"
+type recordingSink struct {
+	events []string
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) record(action string, event *IdentityEvent) error {
+	s.events = append(s.events, fmt.Sprintf("%s %s %s", action, sessionIdentity(event), strings.Join(event.Session.IpAddresses, ",")))
+	return nil
+}
+
+func (s *recordingSink) Login(event *IdentityEvent) error {
+	return s.record("login", event)
+}
+
+func (s *recordingSink) Logout(event *IdentityEvent) error {
+	return s.record("logout", event)
+}
+
+func (s *recordingSink) GroupUpdate(event *IdentityEvent) error {
+	return s.record("update", event)
+}
+
+func newTestUserEvent(state string, ips ...string) *IdentityEvent {
+	return &IdentityEvent{Session: &Sessions{State: state, Username: "jdoe", IpAddresses: ips,
+		AdUserNetBiosName: "EXAMPLE", AdUserSamAccountName: "jdoe"}}
+}
+
+func newTestMachineEvent(state string, ips ...string) *IdentityEvent {
+	return &IdentityEvent{Session: &Sessions{State: state, Username: "host/pc01.example.local", IpAddresses: ips,
+		AdUserNetBiosName: "EXAMPLE"}}
+}
+
+func TestMappingIndexOverridden(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("MACHINE_AUTH_ENABLED", true)
+	tests := []struct {
+		name       string
+		precedence string
+		held       *IdentityEvent
+		event      *IdentityEvent
+		overridden string
+	}{
+		{"user keeps the IP address", PrecedenceUser, newTestUserEvent(AUTHENTICATED, "10.0.0.1"), newTestMachineEvent(AUTHENTICATED, "10.0.0.1"), "EXAMPLE\\jdoe"},
+		{"user takes the IP address", PrecedenceUser, newTestMachineEvent(AUTHENTICATED, "10.0.0.1"), newTestUserEvent(AUTHENTICATED, "10.0.0.1"), ""},
+		{"machine keeps the IP address", PrecedenceMachine, newTestMachineEvent(AUTHENTICATED, "10.0.0.1"), newTestUserEvent(AUTHENTICATED, "10.0.0.1"), "EXAMPLE\\PC01$"},
+		{"machine takes the IP address", PrecedenceMachine, newTestUserEvent(AUTHENTICATED, "10.0.0.1"), newTestMachineEvent(AUTHENTICATED, "10.0.0.1"), ""},
+		{"latest session wins", PrecedenceLatest, newTestUserEvent(AUTHENTICATED, "10.0.0.1"), newTestMachineEvent(AUTHENTICATED, "10.0.0.1"), ""},
+		{"logout is never overridden", PrecedenceUser, newTestUserEvent(AUTHENTICATED, "10.0.0.1"), newTestMachineEvent(DISCONNECTED, "10.0.0.1"), ""},
+		{"one of the IP addresses is held", PrecedenceUser, newTestUserEvent(AUTHENTICATED, "10.0.0.2"), newTestMachineEvent(AUTHENTICATED, "10.0.0.1", "10.0.0.2"), "EXAMPLE\\jdoe"},
+		{"other IP address", PrecedenceUser, newTestUserEvent(AUTHENTICATED, "10.0.0.2"), newTestMachineEvent(AUTHENTICATED, "10.0.0.1"), ""},
+	}
+	for _, test := range tests {
+		index := NewMappingIndex(0)
+		if err := index.Login(test.held); err != nil {
+			t.Fatal(err)
+		}
+		mapping, ok := index.Overridden(test.event, test.precedence)
+		if ok != (test.overridden != "") || (ok && mapping.User != test.overridden) {
+			t.Errorf("%s: got %v and %+v instead of %s", test.name, ok, mapping, test.overridden)
+		}
+	}
+}
+
+func TestMappingIndexReleased(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("MACHINE_AUTH_ENABLED", true)
+	index := NewMappingIndex(0)
+	if err := index.Login(newTestMachineEvent(AUTHENTICATED, "10.0.0.1", "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	// a login of the same kind does not release anything, the index moves the IP address itself
+	if released := index.Released(newTestMachineEvent(AUTHENTICATED, "10.0.0.1")); len(released) != 0 {
+		t.Errorf("a machine login released %d sessions of a machine", len(released))
+	}
+	if released := index.Released(newTestUserEvent(DISCONNECTED, "10.0.0.1")); len(released) != 0 {
+		t.Errorf("a logout released %d sessions", len(released))
+	}
+	// the IP addresses taken from the same identity are released in one logout
+	released := index.Released(newTestUserEvent(AUTHENTICATED, "10.0.0.1", "10.0.0.2", "10.0.0.3"))
+	if len(released) != 1 {
+		t.Fatalf("the user login released %d sessions instead of 1", len(released))
+	}
+	logout := released[0].Session
+	if logout.State != DISCONNECTED || strings.Join(logout.IpAddresses, ",") != "10.0.0.1,10.0.0.2" {
+		t.Errorf("the released session is %s on %v", logout.State, logout.IpAddresses)
+	}
+	if identity := sessionIdentity(released[0]); identity != "EXAMPLE\\PC01$" || !isMachineSession(logout) {
+		t.Errorf("the released session is the one of %s", identity)
+	}
+}
+
+func TestSinkSetPrecedence(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("MACHINE_AUTH_ENABLED", true)
+	// the precedence decides which identity keeps an IP address used by a user and a machine
+	tests := []struct {
+		precedence string
+		events     string
+	}{
+		{PrecedenceUser, "login EXAMPLE\\jdoe 10.0.0.1"},
+		{PrecedenceMachine, "login EXAMPLE\\jdoe 10.0.0.1|logout EXAMPLE\\jdoe 10.0.0.1|login EXAMPLE\\PC01$ 10.0.0.1"},
+		{PrecedenceLatest, "login EXAMPLE\\jdoe 10.0.0.1|logout EXAMPLE\\jdoe 10.0.0.1|login EXAMPLE\\PC01$ 10.0.0.1"},
+	}
+	for _, test := range tests {
+		sink := &recordingSink{}
+		index := NewMappingIndex(0)
+		sinkSet := NewSinkSet([]IdentitySink{sink, index}, nil)
+		sinkSet.index = index
+		sinkSet.precedence = test.precedence
+		for _, event := range []*IdentityEvent{newTestUserEvent(AUTHENTICATED, "10.0.0.1"), newTestMachineEvent(AUTHENTICATED, "10.0.0.1")} {
+			if err := sinkSet.Login(event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if events := strings.Join(sink.events, "|"); events != test.events {
+			t.Errorf("%s: the sink got %s instead of %s", test.precedence, events, test.events)
+		}
+		if mapping, ok := index.LookupIP("10.0.0.1"); !ok || mapping.Machine != (test.precedence != PrecedenceUser) {
+			t.Errorf("%s: the index maps the IP address to %+v", test.precedence, mapping)
+		}
+	}
+}
+
+func TestMachineAuthDisabled(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	// a host/ session is a user session when the machine authentication is not enabled
+	event := newTestMachineEvent(AUTHENTICATED, "10.0.0.1")
+	event.Session.IsMachineAuthentication = "true"
+	if isMachineSession(event.Session) {
+		t.Error("a session is a machine session with MACHINE_AUTH_ENABLED unset")
+	}
+	event.Session.AdUserNetBiosName = ""
+	if _, err := sessionAccount(event.Session); err == nil || strings.Contains(err.Error(), "AD_NETBIOS_NAME") {
+		t.Errorf("a host/ session without domain got the machine error %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SinkIndex = "index"
+
+// Mapping is an active session of the mapping index
+type Mapping struct {
+	User                     string     `json:"user"`
+	IpAddresses              []string   `json:"ipAddresses"`
+	Machine                  bool       `json:"machine,omitempty"`
+	MacAddress               string     `json:"macAddress,omitempty"`
+	NasIpAddress             string     `json:"nasIpAddress,omitempty"`
+	NasIdentifier            string     `json:"nasIdentifier,omitempty"`
+	NetworkDeviceProfileName string     `json:"networkDeviceProfileName,omitempty"`
+	State                    string     `json:"state"`
+	Source                   string     `json:"source,omitempty"`
+	Tags                     []string   `json:"tags,omitempty"`
+	Timestamp                *time.Time `json:"timestamp"`
+	updated                  time.Time
+}
+
+// MappingIndex keeps the current IP, user and MAC mappings built from the processed sessions.
+// it is an identity sink, so it is updated with the same events as FUID
+type MappingIndex struct {
+	mutex    sync.RWMutex
+	ttl      time.Duration
+	sessions map[string]*Mapping
+	byIP     map[string]string
+	byMac    map[string]string
+	byUser   map[string]map[string]bool
+}
+
+// NewMappingIndex create an empty index, sessions without update during ttl are removed, a zero ttl keeps them
+func NewMappingIndex(ttl time.Duration) *MappingIndex {
+	return &MappingIndex{
+		ttl:      ttl,
+		sessions: make(map[string]*Mapping),
+		byIP:     make(map[string]string),
+		byMac:    make(map[string]string),
+		byUser:   make(map[string]map[string]bool),
+	}
+}
+
+func (m *MappingIndex) Name() string {
+	return SinkIndex
+}
+
+// Login add or update the session mapping, the session IP addresses are removed from any other session
+func (m *MappingIndex) Login(event *IdentityEvent) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.prune()
+	m.add(newMapping(event))
+	return nil
+}
+
+// Restore add the IP addresses of the FUID users, so the identity holding an IP address is known after a restart.
+// a user whose sAMAccountName ends with $ is a computer account. the number of restored users is returned
+func (m *MappingIndex) Restore(users *AllUsers) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	count := 0
+	for _, user := range users.Users {
+		if user.NTLMIdentity == "" || len(user.Ipv4Addresses) == 0 {
+			continue
+		}
+		m.add(&Mapping{
+			User:        user.NTLMIdentity,
+			IpAddresses: append([]string(nil), user.Ipv4Addresses...),
+			Machine:     strings.HasSuffix(user.SAMAccountName, "$") || strings.HasSuffix(user.NTLMIdentity, "$"),
+			State:       AUTHENTICATED,
+			updated:     time.Now(),
+		})
+		count++
+	}
+	return count
+}
+
+// add a session mapping, the caller holds the lock
+func (m *MappingIndex) add(mapping *Mapping) {
+	key := mappingKey(mapping)
+	m.remove(key)
+	for _, ip := range mapping.IpAddresses {
+		if otherKey, ok := m.byIP[ip]; ok {
+			m.removeIP(otherKey, ip)
+		}
+		m.byIP[ip] = key
+	}
+	if mapping.MacAddress != "" {
+		m.byMac[mapping.MacAddress] = key
+	}
+	userKey := strings.ToLower(mapping.User)
+	if m.byUser[userKey] == nil {
+		m.byUser[userKey] = make(map[string]bool)
+	}
+	m.byUser[userKey][key] = true
+	m.sessions[key] = mapping
+}
+
+// Logout remove the session mapping
+func (m *MappingIndex) Logout(event *IdentityEvent) error {
+	mapping := newMapping(event)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.remove(mappingKey(mapping))
+	for _, ip := range mapping.IpAddresses {
+		if key, ok := m.byIP[ip]; ok && strings.EqualFold(m.sessions[key].User, mapping.User) {
+			m.removeIP(key, ip)
+		}
+	}
+	return nil
+}
+
+// GroupUpdate refresh the session mapping
+func (m *MappingIndex) GroupUpdate(event *IdentityEvent) error {
+	return m.Login(event)
+}
+
+// LookupIP return the session holding an IP address
+func (m *MappingIndex) LookupIP(ip string) (*Mapping, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	key, ok := m.byIP[ip]
+	if !ok {
+		return nil, false
+	}
+	mapping := *m.sessions[key]
+	return &mapping, true
+}
+
+// LookupMac return the session of a MAC address
+func (m *MappingIndex) LookupMac(mac string) (*Mapping, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	key, ok := m.byMac[normalizeMac(mac)]
+	if !ok {
+		return nil, false
+	}
+	mapping := *m.sessions[key]
+	return &mapping, true
+}
+
+// LookupUser return the sessions of a user, the user is matched case insensitive
+func (m *MappingIndex) LookupUser(user string) []Mapping {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var mappings []Mapping
+	for key := range m.byUser[strings.ToLower(user)] {
+		mappings = append(mappings, *m.sessions[key])
+	}
+	sortMappings(mappings)
+	return mappings
+}
+
+// Sessions return all the active sessions
+func (m *MappingIndex) Sessions() []Mapping {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	mappings := make([]Mapping, 0, len(m.sessions))
+	for _, mapping := range m.sessions {
+		mappings = append(mappings, *mapping)
+	}
+	sortMappings(mappings)
+	return mappings
+}
+
+// remove delete a session and its lookups, the caller holds the lock
+func (m *MappingIndex) remove(key string) {
+	mapping, ok := m.sessions[key]
+	if !ok {
+		return
+	}
+	for _, ip := range mapping.IpAddresses {
+		if m.byIP[ip] == key {
+			delete(m.byIP, ip)
+		}
+	}
+	if m.byMac[mapping.MacAddress] == key {
+		delete(m.byMac, mapping.MacAddress)
+	}
+	userKey := strings.ToLower(mapping.User)
+	delete(m.byUser[userKey], key)
+	if len(m.byUser[userKey]) == 0 {
+		delete(m.byUser, userKey)
+	}
+	delete(m.sessions, key)
+}
+
+// removeIP remove one IP address from a session, a session without IP address is removed, the caller holds the lock
+func (m *MappingIndex) removeIP(key, ip string) {
+	delete(m.byIP, ip)
+	mapping, ok := m.sessions[key]
+	if !ok {
+		return
+	}
+	var ipAddresses []string
+	for _, address := range mapping.IpAddresses {
+		if address != ip {
+			ipAddresses = append(ipAddresses, address)
+		}
+	}
+	mapping.IpAddresses = ipAddresses
+	if len(ipAddresses) == 0 {
+		m.remove(key)
+	}
+}
+
+// prune remove the sessions older than the ttl, the caller holds the lock
+func (m *MappingIndex) prune() {
+	if m.ttl <= 0 {
+		return
+	}
+	expired := time.Now().Add(-m.ttl)
+	for key, mapping := range m.sessions {
+		if mapping.updated.Before(expired) {
+			m.remove(key)
+		}
+	}
+}
+
+func newMapping(event *IdentityEvent) *Mapping {
+	sess := event.Session
+	mapping := &Mapping{
+		User:                     sessionIdentity(event),
+		IpAddresses:              append([]string(nil), sess.IpAddresses...),
+		Machine:                  isMachineSession(sess),
+		MacAddress:               normalizeMac(sess.MacAddress),
+		NasIpAddress:             sess.NasIpAddress,
+		NasIdentifier:            sess.NasIdentifier,
+		NetworkDeviceProfileName: sess.NetworkDeviceProfileName,
+		State:                    sess.State,
+		Source:                   event.Source,
+		Tags:                     event.Tags,
+		Timestamp:                sess.Timestamp,
+		updated:                  time.Now(),
+	}
+	return mapping
+}
+
+// mappingKey identify a session by its MAC address, or by its user and IP addresses when there is no MAC address
+func mappingKey(mapping *Mapping) string {
+	if mapping.MacAddress != "" {
+		return "mac:" + mapping.MacAddress
+	}
+	return fmt.Sprintf("user:%s:%s", strings.ToLower(mapping.User), strings.Join(mapping.IpAddresses, ","))
+}
+
+// sessionIdentity return the FUID NTLM identity of an event, or the identity built from the session
+func sessionIdentity(event *IdentityEvent) string {
+	if event.User != nil && event.User.NTLMIdentity != "" {
+		return event.User.NTLMIdentity
+	}
+	if account, err := sessionAccount(event.Session); err == nil {
+		return account.NTLMIdentity()
+	}
+	if event.Session.AdUserNetBiosName != "" {
+		return fmt.Sprintf("%s\\%s", event.Session.AdUserNetBiosName, sessionUserName(event.Session))
+	}
+	return sessionUserName(event.Session)
+}
+
+// normalizeMac format a MAC address as AA:BB:CC:DD:EE:FF, the formats aa-bb-cc-dd-ee-ff and aabb.ccdd.eeff are accepted
+func normalizeMac(mac string) string {
+	digits := strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.TrimSpace(mac)))
+	if len(digits) != 12 {
+		return strings.ToUpper(strings.TrimSpace(mac))
+	}
+	parts := make([]string, 0, 6)
+	for i := 0; i < 12; i += 2 {
+		parts = append(parts, digits[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+func sortMappings(mappings []Mapping) {
+	sort.Slice(mappings, func(i, j int) bool {
+		if mappings[i].User != mappings[j].User {
+			return mappings[i].User < mappings[j].User
+		}
+		return strings.Join(mappings[i].IpAddresses, ",") < strings.Join(mappings[j].IpAddresses, ",")
+	})
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the kinds of metrics
+const (
+	MetricCounter = "counter"
+	MetricGauge   = "gauge"
+)
+
+// Labels are the labels of a metric value
+type Labels map[string]string
+
+// String render the labels in the Prometheus text format
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(l[name])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// metricFamily is a metric with its values per labels
+type metricFamily struct {
+	kind   string
+	help   string
+	values map[string]float64
+}
+
+// Metrics is a registry of counters and gauges, served in the Prometheus text format by the local API
+type Metrics struct {
+	mutex    sync.Mutex
+	families map[string]*metricFamily
+}
+
+// DefaultMetrics is the registry of the process
+var DefaultMetrics = NewMetrics()
+
+// NewMetrics create an empty registry
+func NewMetrics() *Metrics {
+	return &Metrics{families: make(map[string]*metricFamily)}
+}
+
+// Describe set the kind and the help text of a metric
+func (m *Metrics) Describe(name, kind, help string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	family := m.family(name)
+	family.kind = kind
+	family.help = help
+}
+
+// Add add delta to a metric value
+func (m *Metrics) Add(name string, labels Labels, delta float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.family(name).values[labels.String()] += delta
+}
+
+// Set set a metric value
+func (m *Metrics) Set(name string, labels Labels, value float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.family(name).values[labels.String()] = value
+}
+
+// Value return a metric value
+func (m *Metrics) Value(name string, labels Labels) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.family(name).values[labels.String()]
+}
+
+// WriteText write the metrics in the Prometheus text format, sorted by name
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writer := bufio.NewWriter(w)
+	for _, name := range names {
+		family := m.families[name]
+		if family.help != "" {
+			_, _ = fmt.Fprintf(writer, "# HELP %s %s\n", name, family.help)
+		}
+		kind := family.kind
+		if kind == "" {
+			kind = "untyped"
+		}
+		_, _ = fmt.Fprintf(writer, "# TYPE %s %s\n", name, kind)
+		labels := make([]string, 0, len(family.values))
+		for label := range family.values {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			_, _ = fmt.Fprintf(writer, "%s%s %g\n", name, label, family.values[label])
+		}
+	}
+	return writer.Flush()
+}
+
+func (m *Metrics) family(name string) *metricFamily {
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{values: make(map[string]float64)}
+		m.families[name] = family
+	}
+	return family
+}
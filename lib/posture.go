@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"github.com/spf13/viper"
+	"strings"
+)
+
+// postureGroup is a pseudo-group added while a posture condition holds
+type postureGroup struct {
+	dn string
+	// state report whether the session holds the condition and whether the session carries the posture data
+	state func(sess *Sessions) (holds, known bool)
+}
+
+// PostureGroupMapper add pseudo-groups to the users of non-compliant, jailbroken or unencrypted endpoints.
+// a group is removed when a later session shows the endpoint compliant, it is kept when a session has no posture data
+type PostureGroupMapper struct {
+	groups []postureGroup
+}
+
+// NewPostureGroupMapper create the mapper from the POSTURE_* configs, nil is returned when POSTURE_GROUPS_ENABLED is false
+func NewPostureGroupMapper() *PostureGroupMapper {
+	if !viper.GetBool("POSTURE_GROUPS_ENABLED") {
+		return nil
+	}
+	mapper := &PostureGroupMapper{}
+	mapper.add(viper.GetString("POSTURE_NON_COMPLIANT_GROUP"), func(sess *Sessions) (bool, bool) {
+		status := postureStatus(sess)
+		postureKnown := status != "" && status != "unknown" && status != "none" && status != "notapplicable"
+		holds := (sess.MdmRegistered && !sess.MdmCompliant) || status == "noncompliant"
+		return holds, sess.MdmRegistered || postureKnown
+	})
+	mapper.add(viper.GetString("POSTURE_JAILBROKEN_GROUP"), func(sess *Sessions) (bool, bool) {
+		return sess.MdmJailBroken, sess.MdmRegistered
+	})
+	mapper.add(viper.GetString("POSTURE_UNENCRYPTED_GROUP"), func(sess *Sessions) (bool, bool) {
+		return !sess.MdmDiskEncrypted, sess.MdmRegistered
+	})
+	return mapper
+}
+
+// add register a group, a group without DN is disabled
+func (p *PostureGroupMapper) add(dn string, state func(sess *Sessions) (bool, bool)) {
+	if dn != "" {
+		p.groups = append(p.groups, postureGroup{dn: dn, state: state})
+	}
+}
+
+// Merge set the posture groups of the session, the groups of an unknown posture are taken from the current groups
+func (p *PostureGroupMapper) Merge(groups, current []string, sess *Sessions) []string {
+	merged := make([]string, 0, len(groups))
+	for _, group := range groups {
+		if !p.isManaged(group) {
+			merged = append(merged, group)
+		}
+	}
+	for _, group := range p.groups {
+		holds, known := group.state(sess)
+		if !known {
+			holds = containsGroup(current, group.dn)
+		}
+		if holds {
+			merged = append(merged, group.dn)
+		}
+	}
+	return merged
+}
+
+func (p *PostureGroupMapper) isManaged(dn string) bool {
+	for _, group := range p.groups {
+		if strings.EqualFold(group.dn, dn) {
+			return true
+		}
+	}
+	return false
+}
+
+// postureStatus return the normalized ISE posture status of a session, NonCompliant and non_compliant become noncompliant
+func postureStatus(sess *Sessions) string {
+	status := sess.PostureStatus
+	if status == "" {
+		status = sess.EndpointCheckResult
+	}
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "", " ", "").Replace(status))
+}
+
+func containsGroup(groups []string, dn string) bool {
+	for _, group := range groups {
+		if strings.EqualFold(group, dn) {
+			return true
+		}
+	}
+	return false
+}
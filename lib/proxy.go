@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// the proxy settings prefixes, ISE_CONTROL and ISE_SESSION share the ISE proxy
+const (
+	ProxyISE  = "ISE"
+	ProxyFUID = "FUID"
+)
+
+// ProxyConfig holds the egress proxy settings of a target.
+// when no proxy URL is configured, the HTTPS_PROXY and NO_PROXY environment variables are used
+type ProxyConfig struct {
+	Url     *url.URL
+	NoProxy []string
+}
+
+// NewProxyConfig read the proxy settings with the given prefix from the config
+func NewProxyConfig(prefix string) (*ProxyConfig, error) {
+	proxyConfig := &ProxyConfig{}
+	rawUrl := viper.GetString(prefix + "_PROXY_URL")
+	if rawUrl == "" {
+		return proxyConfig, nil
+	}
+	proxyUrl, err := url.Parse(rawUrl)
+	if err != nil || proxyUrl.Host == "" {
+		return nil, errors.Errorf("invalid %s_PROXY_URL '%s', expected format http://host:port", prefix, rawUrl)
+	}
+	if proxyUrl.Scheme != "http" && proxyUrl.Scheme != "https" {
+		return nil, errors.Errorf("unsupported %s_PROXY_URL scheme '%s', supported schemes are http and https", prefix, proxyUrl.Scheme)
+	}
+	if viper.GetString(prefix+"_PROXY_USERNAME") != "" {
+		proxyUrl.User = url.UserPassword(viper.GetString(prefix+"_PROXY_USERNAME"), viper.GetString(prefix+"_PROXY_PASSWORD"))
+	}
+	proxyConfig.Url = proxyUrl
+	noProxy := viper.GetString(prefix + "_NO_PROXY")
+	if noProxy == "" {
+		noProxy = getEnvAny("NO_PROXY", "no_proxy")
+	}
+	for _, entry := range strings.Split(noProxy, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			proxyConfig.NoProxy = append(proxyConfig.NoProxy, strings.ToLower(entry))
+		}
+	}
+	return proxyConfig, nil
+}
+
+// Proxy return the proxy URL for a request, it is used as the Proxy function of http.Transport
+func (p *ProxyConfig) Proxy(req *http.Request) (*url.URL, error) {
+	if p == nil || p.Url == nil {
+		return http.ProxyFromEnvironment(req)
+	}
+	if p.bypass(req.URL.Hostname()) {
+		return nil, nil
+	}
+	return p.Url, nil
+}
+
+// DialContext open a TCP connection to address, through an HTTP CONNECT tunnel when a proxy applies
+func (p *ProxyConfig) DialContext(ctx context.Context, dialer *net.Dialer, address string) (net.Conn, error) {
+	req, err := http.NewRequest(http.MethodConnect, "https://"+address, nil)
+	if err != nil {
+		return nil, err
+	}
+	proxyUrl, err := p.Proxy(req)
+	if err != nil {
+		return nil, err
+	}
+	if proxyUrl == nil {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+	proxyAddress := proxyUrl.Host
+	if proxyUrl.Port() == "" {
+		proxyAddress = net.JoinHostPort(proxyUrl.Hostname(), map[string]string{"http": "80", "https": "443"}[proxyUrl.Scheme])
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot connect to the proxy %s", proxyAddress)
+	}
+	if proxyUrl.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyUrl.Hostname()})
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	connectRequest := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if proxyUrl.User != nil {
+		password, _ := proxyUrl.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyUrl.User.Username() + ":" + password))
+		connectRequest += fmt.Sprintf("Proxy-Authorization: Basic %s\r\n", credentials)
+	}
+	if _, err := conn.Write([]byte(connectRequest + "\r\n")); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "cannot read the CONNECT response of the proxy %s", proxyAddress)
+	}
+	// the body of a successful CONNECT response is the tunnel itself, so it is not read
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, errors.Errorf("the proxy %s refused to connect to %s: %s", proxyAddress, address, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// bypass report whether a host matches the no-proxy list
+func (p *ProxyConfig) bypass(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range p.NoProxy {
+		if entry == "*" {
+			return true
+		}
+		if ip != nil {
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+				return true
+			}
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, "*")
+		if host == strings.TrimPrefix(entry, ".") || (strings.HasPrefix(entry, ".") && strings.HasSuffix(host, entry)) ||
+			(!strings.HasPrefix(entry, ".") && strings.HasSuffix(host, "."+entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
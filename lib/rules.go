@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"path"
+	"reflect"
+	"strings"
+)
+
+const (
+	RuleActionInclude = "include"
+	RuleActionExclude = "exclude"
+	RuleActionTag     = "tag"
+)
+
+// sessionFields map the lower case JSON name of every Sessions field to its index
+var sessionFields = func() map[string]int {
+	fields := make(map[string]int)
+	sessionType := reflect.TypeOf(Sessions{})
+	for i := 0; i < sessionType.NumField(); i++ {
+		name := strings.Split(sessionType.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[strings.ToLower(name)] = i
+		}
+	}
+	return fields
+}()
+
+// SessionRule match the sessions having all the fields of Match, a field matches when its value matches one of the patterns.
+// a pattern is a case insensitive glob, or a CIDR for the IP address fields
+type SessionRule struct {
+	Name   string                 `mapstructure:"name" json:"name"`
+	Match  map[string]interface{} `mapstructure:"match" json:"match"`
+	Action string                 `mapstructure:"action" json:"action"`
+	Tags   []string               `mapstructure:"tags" json:"tags,omitempty"`
+	fields map[int][]string
+}
+
+// RuleDecision is the result of the rules for a session
+type RuleDecision struct {
+	Include bool     `json:"include"`
+	Rule    string   `json:"rule,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// RuleSet evaluate the session rules in order, the first include or exclude rule matching a session decides,
+// the tags of all the matching tag rules before it are kept. sessions matching no rule get the default action
+type RuleSet struct {
+	rules         []*SessionRule
+	defaultAction string
+}
+
+// NewRuleSet validate the rules and create a rule set
+func NewRuleSet(rules []*SessionRule, defaultAction string) (*RuleSet, error) {
+	defaultAction = strings.ToLower(defaultAction)
+	if defaultAction == "" {
+		defaultAction = RuleActionInclude
+	}
+	if defaultAction != RuleActionInclude && defaultAction != RuleActionExclude {
+		return nil, errors.Errorf("unsupported SESSION_RULES_DEFAULT_ACTION '%s', supported actions are include and exclude", defaultAction)
+	}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		rule.Action = strings.ToLower(rule.Action)
+		if rule.Action != RuleActionInclude && rule.Action != RuleActionExclude && rule.Action != RuleActionTag {
+			return nil, errors.Errorf("unsupported action '%s' in session rule %s, supported actions are include, exclude and tag", rule.Action, rule.Name)
+		}
+		if rule.Action == RuleActionTag && len(rule.Tags) == 0 {
+			return nil, errors.Errorf("the tag session rule %s has no tags", rule.Name)
+		}
+		if len(rule.Match) == 0 {
+			return nil, errors.Errorf("the session rule %s has no match", rule.Name)
+		}
+		rule.fields = make(map[int][]string, len(rule.Match))
+		for field, value := range rule.Match {
+			index, ok := sessionFields[strings.ToLower(field)]
+			if !ok {
+				return nil, errors.Errorf("unknown session field '%s' in session rule %s", field, rule.Name)
+			}
+			patterns := rulePatterns(value)
+			for _, pattern := range patterns {
+				if err := validatePattern(pattern); err != nil {
+					return nil, errors.Wrapf(err, "session rule %s", rule.Name)
+				}
+			}
+			rule.fields[index] = patterns
+		}
+	}
+	return &RuleSet{rules: rules, defaultAction: defaultAction}, nil
+}
+
+// Evaluate return the decision of the rules for a session, a nil rule set includes every session
+func (r *RuleSet) Evaluate(sess *Sessions) *RuleDecision {
+	if r == nil {
+		return &RuleDecision{Include: true}
+	}
+	decision := &RuleDecision{Include: r.defaultAction == RuleActionInclude}
+	for _, rule := range r.rules {
+		if !rule.matches(sess) {
+			continue
+		}
+		if rule.Action == RuleActionTag {
+			decision.Tags = append(decision.Tags, rule.Tags...)
+			continue
+		}
+		decision.Include = rule.Action == RuleActionInclude
+		decision.Rule = rule.Name
+		break
+	}
+	return decision
+}
+
+func (s *SessionRule) matches(sess *Sessions) bool {
+	value := reflect.ValueOf(sess).Elem()
+	for index, patterns := range s.fields {
+		if !fieldMatches(value.Field(index), patterns) {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldMatches report whether a field value, or one of its elements for a list, matches one of the patterns
+func fieldMatches(field reflect.Value, patterns []string) bool {
+	var values []string
+	switch field.Kind() {
+	case reflect.Slice:
+		for i := 0; i < field.Len(); i++ {
+			values = append(values, fmt.Sprint(field.Index(i).Interface()))
+		}
+	case reflect.Ptr:
+		if field.IsNil() {
+			values = append(values, "")
+		} else {
+			values = append(values, fmt.Sprint(field.Elem().Interface()))
+		}
+	default:
+		values = append(values, fmt.Sprint(field.Interface()))
+	}
+	for _, value := range values {
+		for _, pattern := range patterns {
+			if patternMatches(pattern, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func patternMatches(pattern, value string) bool {
+	if strings.Contains(pattern, "/") {
+		if _, network, err := net.ParseCIDR(pattern); err == nil {
+			ip := net.ParseIP(value)
+			return ip != nil && network.Contains(ip)
+		}
+	}
+	matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return err == nil && matched
+}
+
+// validatePattern return an error for a malformed glob, or for a CIDR whose address is valid and the prefix is not.
+// the other patterns with a slash are globs, such as the host/ usernames of the machine sessions
+func validatePattern(pattern string) error {
+	if slash := strings.Index(pattern, "/"); slash > 0 && net.ParseIP(pattern[:slash]) != nil {
+		if _, _, err := net.ParseCIDR(pattern); err != nil {
+			return errors.Errorf("invalid CIDR '%s'", pattern)
+		}
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return errors.Errorf("invalid pattern '%s'", pattern)
+	}
+	return nil
+}
+
+// rulePatterns turn a match value of the YAML config into its patterns
+func rulePatterns(value interface{}) []string {
+	var patterns []string
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			patterns = append(patterns, fmt.Sprint(v))
+		}
+		return patterns
+	}
+	return []string{fmt.Sprint(value)}
+}
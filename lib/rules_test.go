@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestRuleSet(t *testing.T, defaultAction string) *RuleSet {
+	ruleSet, err := NewRuleSet([]*SessionRule{
+		{Name: "vpn", Match: map[string]interface{}{"nasIpAddress": "10.1.0.0/16"}, Action: "tag", Tags: []string{"vpn"}},
+		{Name: "wired", Match: map[string]interface{}{"networkDeviceProfileName": "Cisco*"}, Action: "TAG", Tags: []string{"wired", "cisco"}},
+		{Name: "guests", Match: map[string]interface{}{"userName": "guest*"}, Action: "exclude"},
+		{Match: map[string]interface{}{"userName": []interface{}{"admin*", "root"}, "ipAddresses": "10.0.0.0/8"}, Action: "include"},
+		{Name: "machines", Match: map[string]interface{}{"userName": "host/*", "mdmCompliant": false}, Action: "exclude"},
+	}, defaultAction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ruleSet
+}
+
+func TestRuleSetEvaluate(t *testing.T) {
+	tests := []struct {
+		name          string
+		defaultAction string
+		sess          *Sessions
+		include       bool
+		rule          string
+		tags          string
+	}{
+		{"no match with the default include", "", &Sessions{Username: "jdoe"}, true, "", ""},
+		{"no match with the default exclude", "exclude", &Sessions{Username: "jdoe"}, false, "", ""},
+		{"exclude glob", "include", &Sessions{Username: "guest-42"}, false, "guests", ""},
+		{"case insensitive glob", "include", &Sessions{Username: "GUEST-42"}, false, "guests", ""},
+		{"include on every field", "exclude", &Sessions{Username: "admin1", IpAddresses: []string{"10.2.3.4"}}, true, "rule-4", ""},
+		{"one field does not match", "exclude", &Sessions{Username: "admin1", IpAddresses: []string{"192.168.1.1"}}, false, "", ""},
+		{"one element of a list matches", "exclude", &Sessions{Username: "root", IpAddresses: []string{"192.168.1.1", "10.2.3.4"}}, true, "rule-4", ""},
+		{"CIDR with a value which is not an IP address", "exclude", &Sessions{Username: "root", IpAddresses: []string{"unknown"}}, false, "", ""},
+		{"first match decides", "exclude", &Sessions{Username: "guest-admin", IpAddresses: []string{"10.2.3.4"}}, false, "guests", ""},
+		{"tags before the decision", "include", &Sessions{Username: "guest-1", NasIpAddress: "10.1.2.3"}, false, "guests", "vpn"},
+		{"tags of several rules", "include", &Sessions{Username: "jdoe", NasIpAddress: "10.1.2.3", NetworkDeviceProfileName: "Cisco"}, true, "", "vpn,wired,cisco"},
+		{"tag CIDR does not match", "include", &Sessions{Username: "jdoe", NasIpAddress: "10.2.0.1"}, true, "", ""},
+		{"glob with a slash and a boolean field", "include", &Sessions{Username: "host/pc1.example.com"}, false, "machines", ""},
+		{"boolean field does not match", "include", &Sessions{Username: "host/pc1.example.com", MdmCompliant: true}, true, "", ""},
+	}
+	for _, test := range tests {
+		decision := newTestRuleSet(t, test.defaultAction).Evaluate(test.sess)
+		if decision.Include != test.include || decision.Rule != test.rule || strings.Join(decision.Tags, ",") != test.tags {
+			t.Errorf("%s: got %+v instead of include %t, rule '%s' and tags '%s'", test.name, decision, test.include, test.rule, test.tags)
+		}
+	}
+	var ruleSet *RuleSet
+	if decision := ruleSet.Evaluate(&Sessions{Username: "guest-1"}); !decision.Include {
+		t.Error("a nil rule set excluded a session")
+	}
+}
+
+func TestNewRuleSetErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		rule          *SessionRule
+		defaultAction string
+	}{
+		{"unknown field", &SessionRule{Match: map[string]interface{}{"department": "sales"}, Action: "include"}, ""},
+		{"invalid CIDR prefix", &SessionRule{Match: map[string]interface{}{"ipAddresses": "10.0.0.0/33"}, Action: "include"}, ""},
+		{"invalid CIDR in a list", &SessionRule{Match: map[string]interface{}{"nasIpAddress": []interface{}{"10.0.0.0/8", "10.0.0.1/x"}}, Action: "include"}, ""},
+		{"invalid glob", &SessionRule{Match: map[string]interface{}{"userName": "[a-"}, Action: "include"}, ""},
+		{"unsupported action", &SessionRule{Match: map[string]interface{}{"userName": "jdoe"}, Action: "drop"}, ""},
+		{"tag rule without tags", &SessionRule{Match: map[string]interface{}{"userName": "jdoe"}, Action: "tag"}, ""},
+		{"rule without match", &SessionRule{Action: "include"}, ""},
+		{"unsupported default action", &SessionRule{Match: map[string]interface{}{"userName": "jdoe"}, Action: "include"}, "tag"},
+	}
+	for _, test := range tests {
+		if _, err := NewRuleSet([]*SessionRule{test.rule}, test.defaultAction); err == nil {
+			t.Errorf("%s: the rule is accepted", test.name)
+		}
+	}
+	// the field names are case insensitive
+	if _, err := NewRuleSet([]*SessionRule{{Match: map[string]interface{}{"USERNAME": "jdoe"}, Action: "include"}}, ""); err != nil {
+		t.Errorf("an upper case field name is rejected: %s", err)
+	}
+}
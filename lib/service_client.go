@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// ServiceClient call the REST API of a pxGrid service provider, the provider and its access secret come from the service lookup
+type ServiceClient struct {
+	mutex       sync.Mutex
+	controller  *Controller
+	serviceName string
+	restBaseUrl string
+	nodeName    string
+	secret      string
+}
+
+// NewServiceClient look up a pxGrid service and get the access secret of its provider node
+func NewServiceClient(serviceName string, controller *Controller) (*ServiceClient, error) {
+	client := &ServiceClient{controller: controller, serviceName: serviceName}
+	if err := client.lookup(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Send call a service endpoint and decode the JSON response in output, the service is looked up again once when the access secret is rejected
+func (s *ServiceClient) Send(endpoint string, requestBody interface{}, idempotent bool, output interface{}) error {
+	for attempt := 0; ; attempt++ {
+		s.mutex.Lock()
+		requestUrl := fmt.Sprintf("%s/%s", s.restBaseUrl, endpoint)
+		secret := s.secret
+		s.mutex.Unlock()
+		resp, err := s.controller.ServiceRequest(secret, requestUrl, requestBody, idempotent)
+		if err != nil {
+			return err
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if err := s.lookup(); err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			if len(respBody) != 0 {
+				return errors.New(fmt.Sprintf("UnexpectedResponseError: status_code: %d, statusReason: %s, Body: %s", resp.StatusCode, resp.Status, string(respBody)))
+			}
+			return errors.New(fmt.Sprintf("UnexpectedResponseError: status_code: %d, statusReason: %s", resp.StatusCode, resp.Status))
+		}
+		return json.Unmarshal(respBody, output)
+	}
+}
+
+// lookup find the service provider and request its access secret
+func (s *ServiceClient) lookup() error {
+	serviceLookupOutput, err := ServiceLookupRequest(s.serviceName, s.controller)
+	if err != nil {
+		return err
+	}
+	restBaseUrl, nodeName, err := GetServiceRestUrl(serviceLookupOutput.Services)
+	if err != nil {
+		return errors.Wrap(err, s.serviceName)
+	}
+	accessSecretOutput, err := AccessSecret(nodeName, s.controller)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.restBaseUrl = restBaseUrl
+	s.nodeName = nodeName
+	s.secret = accessSecretOutput.Secret
+	return nil
+}
@@ -33,7 +33,7 @@ type ServiceProperties struct {
 
 func ServiceLookupRequest(serviceName string, controller *Controller) (*ServiceLookupOutput, error) {
 	input := ServiceLookupInput{Name: serviceName}
-	requestUrl := GetEndpointUrl(ServiceLookup)
+	requestUrl := controller.EndpointUrl(ServiceLookup)
 	resp, err := controller.SendRequest(requestUrl, &input, http.MethodPost, true)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,196 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+)
+
+const sessionsKey = "sessions"
+
+// SessionStreamError reports a truncated or malformed tail of a getSessions response
+type SessionStreamError struct {
+	Decoded int
+	Lost    int
+	Err     error
+}
+
+func (e *SessionStreamError) Error() string {
+	return fmt.Sprintf("malformed getSessions response after %d sessions, %d session records lost: %s", e.Decoded, e.Lost, e.Err)
+}
+
+// SessionRecordError reports a single session record which could not be decoded, the decoder can continue after it
+type SessionRecordError struct {
+	Index int
+	Err   error
+}
+
+func (e *SessionRecordError) Error() string {
+	return fmt.Sprintf("cannot decode session record %d: %s", e.Index, e.Err)
+}
+
+// SessionDecoder decodes the sessions array of a getSessions response one session at a time
+type SessionDecoder struct {
+	reader  *readErrorReader
+	decoder *json.Decoder
+	index   int
+	decoded int
+	started bool
+	done    bool
+}
+
+// NewSessionDecoder create a decoder reading a getSessions response from r
+func NewSessionDecoder(r io.Reader) *SessionDecoder {
+	reader := &readErrorReader{Reader: r}
+	return &SessionDecoder{reader: reader, decoder: json.NewDecoder(reader)}
+}
+
+// readErrorReader keep the last read error of a reader, so the read errors are told apart from the decode errors
+type readErrorReader struct {
+	io.Reader
+	err error
+}
+
+func (r *readErrorReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// Decoded return the number of sessions decoded so far
+func (d *SessionDecoder) Decoded() int {
+	return d.decoded
+}
+
+// Next return the next session of the response, io.EOF is returned when there are no more sessions
+func (d *SessionDecoder) Next() (*Sessions, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+	if !d.started {
+		found, err := d.seekSessions()
+		if err != nil {
+			d.done = true
+			return nil, &SessionStreamError{Err: err}
+		}
+		d.started = true
+		if !found {
+			d.done = true
+			return nil, io.EOF
+		}
+	}
+	if !d.decoder.More() {
+		d.done = true
+		if _, err := d.decoder.Token(); err != nil {
+			return nil, &SessionStreamError{Decoded: d.decoded, Err: errors.Wrap(err, "missing the end of the sessions array")}
+		}
+		return nil, io.EOF
+	}
+	var sess Sessions
+	d.index++
+	if err := d.decoder.Decode(&sess); err != nil {
+		if !d.streamError(err) {
+			return nil, &SessionRecordError{Index: d.index, Err: err}
+		}
+		d.done = true
+		lost, scanErr := countRemainingRecords(io.MultiReader(d.decoder.Buffered(), d.reader))
+		if scanErr != nil {
+			err = errors.Wrap(err, scanErr.Error())
+		}
+		return nil, &SessionStreamError{Decoded: d.decoded, Lost: lost, Err: err}
+	}
+	d.decoded++
+	return &sess, nil
+}
+
+// streamError report whether a decode error ends the response. the other errors, such as a value of the wrong type or
+// a malformed timestamp, are about a record which was read entirely, the decoder continues after it
+func (d *SessionDecoder) streamError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr) || err == io.ErrUnexpectedEOF || err == io.EOF || (d.reader.err != nil && err == d.reader.err)
+}
+
+// seekSessions move the decoder to the first element of the sessions array
+func (d *SessionDecoder) seekSessions() (bool, error) {
+	token, err := d.decoder.Token()
+	if err != nil {
+		return false, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return false, errors.Errorf("expected a JSON object, found %v", token)
+	}
+	for d.decoder.More() {
+		token, err := d.decoder.Token()
+		if err != nil {
+			return false, err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return false, errors.Errorf("expected an object key, found %v", token)
+		}
+		if key != sessionsKey {
+			var skipped json.RawMessage
+			if err := d.decoder.Decode(&skipped); err != nil {
+				return false, err
+			}
+			continue
+		}
+		token, err = d.decoder.Token()
+		if err != nil {
+			return false, err
+		}
+		if token == nil {
+			return false, nil
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return false, errors.Errorf("expected the sessions array, found %v", token)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// countRemainingRecords count the objects left in the sessions array, starting with the record that failed to decode
+func countRemainingRecords(r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+	count, depth := 0, 0
+	inString, escaped := false, false
+	for {
+		c, err := reader.ReadByte()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			if depth == 0 && c == '{' {
+				count++
+			}
+			depth++
+		case '}', ']':
+			depth--
+			if depth < 0 {
+				return count, nil
+			}
+		}
+	}
+}
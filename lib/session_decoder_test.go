@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// failingReader fail every read, as a broken connection
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+// decodeTestSessions decode a getSessions response, the usernames of the decoded sessions, the indexes of the records
+// which could not be decoded and the stream error are returned
+func decodeTestSessions(t *testing.T, r io.Reader) ([]string, []int, *SessionStreamError) {
+	decoder := NewSessionDecoder(r)
+	var usernames []string
+	var badRecords []int
+	for {
+		sess, err := decoder.Next()
+		if err == io.EOF {
+			return usernames, badRecords, nil
+		}
+		if recordErr, ok := err.(*SessionRecordError); ok {
+			badRecords = append(badRecords, recordErr.Index)
+			continue
+		}
+		if streamErr, ok := err.(*SessionStreamError); ok {
+			if _, err := decoder.Next(); err != io.EOF {
+				t.Errorf("the decoder continued after a stream error: %v", err)
+			}
+			return usernames, badRecords, streamErr
+		}
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		usernames = append(usernames, sess.Username)
+	}
+}
+
+func TestSessionDecoder(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		usernames  string
+		badRecords []int
+		lost       int
+		streamErr  bool
+	}{
+		{
+			name:      "complete response",
+			body:      `{"sessions":[{"userName":"a","timestamp":"2020-06-01T10:00:00.000Z"},{"userName":"b"}]}`,
+			usernames: "a,b",
+		},
+		{
+			name:      "empty sessions",
+			body:      `{"sessions":[]}`,
+			usernames: "",
+		},
+		{
+			name:      "missing sessions key",
+			body:      `{"other":{"sessions":[{"userName":"a"}]}}`,
+			usernames: "",
+		},
+		{
+			name:      "null sessions",
+			body:      `{"sessions":null}`,
+			usernames: "",
+		},
+		{
+			name:      "extra top-level keys",
+			body:      `{"version":"1.0","paging":{"next":[1,"]"]},"sessions":[{"userName":"a"}],"count":1}`,
+			usernames: "a",
+		},
+		{
+			name:       "record of the wrong type in the middle",
+			body:       `{"sessions":[{"userName":"a"},{"userName":"b","ipAddresses":"10.0.0.1"},{"userName":"c"}]}`,
+			usernames:  "a,c",
+			badRecords: []int{2},
+		},
+		{
+			name:       "malformed timestamp in the middle",
+			body:       `{"sessions":[{"userName":"a"},{"userName":"b","timestamp":"yesterday"},{"userName":"c"}]}`,
+			usernames:  "a,c",
+			badRecords: []int{2},
+		},
+		{
+			name:      "truncated tail",
+			body:      `{"sessions":[{"userName":"a"},{"userName":"b"},{"userName":"c","ipAddresses":["10.0.`,
+			usernames: "a,b",
+			lost:      1,
+			streamErr: true,
+		},
+		{
+			name:      "syntax error in the middle",
+			body:      `{"sessions":[{"userName":"a"},{"userName" "b"},{"userName":"c"},{"userName":"d"}]}`,
+			usernames: "a",
+			lost:      3,
+			streamErr: true,
+		},
+		{
+			name:      "missing end of the sessions array",
+			body:      `{"sessions":[{"userName":"a"}`,
+			usernames: "a",
+			streamErr: true,
+		},
+		{
+			name:      "not an object",
+			body:      `[{"userName":"a"}]`,
+			streamErr: true,
+		},
+	}
+	for _, test := range tests {
+		usernames, badRecords, streamErr := decodeTestSessions(t, strings.NewReader(test.body))
+		if strings.Join(usernames, ",") != test.usernames {
+			t.Errorf("%s: decoded the sessions %v instead of %s", test.name, usernames, test.usernames)
+		}
+		if len(badRecords) != len(test.badRecords) || (len(badRecords) != 0 && badRecords[0] != test.badRecords[0]) {
+			t.Errorf("%s: the bad records are %v instead of %v", test.name, badRecords, test.badRecords)
+		}
+		if (streamErr != nil) != test.streamErr {
+			t.Errorf("%s: got the stream error %v", test.name, streamErr)
+			continue
+		}
+		if streamErr != nil && streamErr.Lost != test.lost {
+			t.Errorf("%s: %d records lost instead of %d", test.name, streamErr.Lost, test.lost)
+		}
+	}
+}
+
+func TestSessionDecoderReadError(t *testing.T) {
+	body := io.MultiReader(strings.NewReader(`{"sessions":[{"userName":"a"},{"userName":"b`), failingReader{})
+	usernames, badRecords, streamErr := decodeTestSessions(t, body)
+	if len(usernames) != 1 || len(badRecords) != 0 {
+		t.Errorf("decoded the sessions %v and the bad records %v", usernames, badRecords)
+	}
+	if streamErr == nil {
+		t.Fatal("a read error did not end the response")
+	}
+	if streamErr.Decoded != 1 {
+		t.Errorf("the stream error reports %d decoded sessions instead of 1", streamErr.Decoded)
+	}
+}
@@ -24,11 +24,13 @@ type Sessions struct {
 	AdUserSamAccountName     string     `json:"adUserSamAccountName"`
 	Providers                []string   `json:"providers"`
 	EndpointCheckResult      string     `json:"endpointCheckResult"`
+	PostureStatus            string     `json:"postureStatus"`
 	IdentitySourcePortStart  int        `json:"identitySourcePortStart"`
 	IdentitySourcePortEnd    int        `json:"identitySourcePortEnd"`
 	IdentitySourcePortFirst  int        `json:"identitySourcePortFirst"`
 	IsMachineAuthentication  string     `json:"isMachineAuthentication"`
 	NetworkDeviceProfileName string     `json:"networkDeviceProfileName"`
+	CtsSecurityGroup         string     `json:"ctsSecurityGroup"`
 	MdmRegistered            bool       `json:"mdmRegistered"`
 	MdmCompliant             bool       `json:"mdmCompliant"`
 	MdmDiskEncrypted         bool       `json:"mdmDiskEncrypted"`
@@ -0,0 +1,222 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SyslogFormatCEF  = "cef"
+	SyslogFormatLEEF = "leef"
+	syslogVendor     = "Forcepoint"
+	syslogProduct    = "FUID ISE Integration"
+	syslogVersion    = "1.0"
+	syslogSeverity   = 6 //informational
+	cefSeverity      = "3"
+)
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	leefValueEscaper    = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+// SyslogSink send the login and logout identity mappings as RFC 5424 syslog messages with a CEF or LEEF payload
+type SyslogSink struct {
+	mutex     sync.Mutex
+	address   string
+	protocol  string
+	format    string
+	facility  int
+	appName   string
+	hostname  string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	conn      net.Conn
+}
+
+// NewSyslogSink create a syslog sink from the SYSLOG_* configs
+func NewSyslogSink() (*SyslogSink, error) {
+	sink := &SyslogSink{
+		address:  viper.GetString("SYSLOG_ADDRESS"),
+		protocol: strings.ToLower(viper.GetString("SYSLOG_PROTOCOL")),
+		format:   strings.ToLower(viper.GetString("SYSLOG_FORMAT")),
+		facility: viper.GetInt("SYSLOG_FACILITY"),
+		appName:  viper.GetString("SYSLOG_APP_NAME"),
+		timeout:  time.Duration(viper.GetInt("SYSLOG_TIMEOUT")) * time.Second,
+	}
+	if sink.address == "" {
+		return nil, errors.New("the syslog server address SYSLOG_ADDRESS is not provided")
+	}
+	if sink.protocol != "udp" && sink.protocol != "tcp" && sink.protocol != "tls" {
+		return nil, errors.Errorf("unsupported SYSLOG_PROTOCOL '%s', supported protocols are udp, tcp and tls", sink.protocol)
+	}
+	if sink.format != SyslogFormatCEF && sink.format != SyslogFormatLEEF {
+		return nil, errors.Errorf("unsupported SYSLOG_FORMAT '%s', supported formats are cef and leef", sink.format)
+	}
+	if sink.facility < 0 || sink.facility > 23 {
+		return nil, errors.Errorf("invalid SYSLOG_FACILITY %d, the facility must be between 0 and 23", sink.facility)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	sink.hostname = hostname
+	if sink.protocol == "tls" {
+		host, _, err := net.SplitHostPort(sink.address)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid SYSLOG_ADDRESS")
+		}
+		sink.tlsConfig = &tls.Config{ServerName: host, InsecureSkipVerify: viper.GetBool("SYSLOG_TLS_INSECURE_SKIP_VERIFY")}
+		if viper.GetString("SYSLOG_TLS_CA_PATH") != "" {
+			caCert, err := ioutil.ReadFile(viper.GetString("SYSLOG_TLS_CA_PATH"))
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(caCert)
+			sink.tlsConfig.RootCAs = pool
+		}
+	}
+	return sink, nil
+}
+
+func (s *SyslogSink) Name() string {
+	return SinkSyslog
+}
+
+func (s *SyslogSink) Login(event *IdentityEvent) error {
+	return s.send("login", event)
+}
+
+func (s *SyslogSink) Logout(event *IdentityEvent) error {
+	return s.send("logout", event)
+}
+
+// GroupUpdate is not sent, only the identity mappings are forwarded
+func (s *SyslogSink) GroupUpdate(event *IdentityEvent) error {
+	return nil
+}
+
+// send format an event and write it to the syslog server, the connection is opened again once on failure
+func (s *SyslogSink) send(action string, event *IdentityEvent) error {
+	message := s.formatMessage(action, event, time.Now())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if s.conn, err = s.dial(); err != nil {
+				continue
+			}
+		}
+		if s.timeout > 0 {
+			_ = s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		}
+		if _, err = s.conn.Write(message); err == nil {
+			return nil
+		}
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return errors.Wrapf(err, "cannot send syslog message to %s", s.address)
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.timeout}
+	if s.protocol == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", s.address, s.tlsConfig)
+	}
+	return dialer.Dial(s.protocol, s.address)
+}
+
+// formatMessage build the RFC 5424 message, stream transports use octet counting framing (RFC 6587)
+func (s *SyslogSink) formatMessage(action string, event *IdentityEvent, now time.Time) []byte {
+	var payload string
+	if s.format == SyslogFormatLEEF {
+		payload = formatLEEF(action, event.Session)
+	} else {
+		payload = formatCEF(action, event.Session)
+	}
+	priority := s.facility*8 + syslogSeverity
+	message := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", priority, now.Format("2006-01-02T15:04:05.000Z07:00"),
+		s.hostname, s.appName, os.Getpid(), action, payload)
+	if s.protocol == "udp" {
+		return []byte(message)
+	}
+	return []byte(fmt.Sprintf("%d %s", len(message), message))
+}
+
+// formatCEF format a session as a CEF record
+func formatCEF(action string, sess *Sessions) string {
+	header := strings.Join([]string{"CEF:0", cefHeaderEscaper.Replace(syslogVendor), cefHeaderEscaper.Replace(syslogProduct),
+		syslogVersion, "identity-" + action, cefHeaderEscaper.Replace("user " + action), cefSeverity}, "|")
+	extensions := []string{"act=" + action}
+	add := func(key, value string) {
+		if value != "" {
+			extensions = append(extensions, key+"="+cefExtensionEscaper.Replace(value))
+		}
+	}
+	// the label of a custom string is written with its value only
+	addCustom := func(key, label, value string) {
+		if value != "" {
+			add(key+"Label", label)
+			add(key, value)
+		}
+	}
+	add("suser", sessionUserName(sess))
+	add("sntdom", sess.AdUserNetBiosName)
+	if len(sess.IpAddresses) != 0 {
+		add("src", sess.IpAddresses[0])
+		addCustom("cs1", "ipAddresses", strings.Join(sess.IpAddresses, ","))
+	}
+	add("smac", sess.MacAddress)
+	addCustom("cs2", "nasIpAddress", sess.NasIpAddress)
+	addCustom("cs3", "state", sess.State)
+	if sess.Timestamp != nil {
+		add("rt", fmt.Sprintf("%d", sess.Timestamp.UnixNano()/int64(time.Millisecond)))
+	}
+	return header + "|" + strings.Join(extensions, " ")
+}
+
+// formatLEEF format a session as a LEEF 1.0 record with tab separated attributes
+func formatLEEF(action string, sess *Sessions) string {
+	header := strings.Join([]string{"LEEF:1.0", syslogVendor, syslogProduct, syslogVersion, "identity-" + action}, "|")
+	attributes := []string{"act=" + action}
+	add := func(key, value string) {
+		if value != "" {
+			attributes = append(attributes, key+"="+leefValueEscaper.Replace(value))
+		}
+	}
+	add("usrName", sessionUserName(sess))
+	add("domain", sess.AdUserNetBiosName)
+	if len(sess.IpAddresses) != 0 {
+		add("src", sess.IpAddresses[0])
+		add("ipAddresses", strings.Join(sess.IpAddresses, ","))
+	}
+	add("srcMAC", sess.MacAddress)
+	add("nasIpAddress", sess.NasIpAddress)
+	add("state", sess.State)
+	if sess.Timestamp != nil {
+		add("devTime", sess.Timestamp.Format("Jan 02 2006 15:04:05.000 MST"))
+		add("devTimeFormat", "MMM dd yyyy HH:mm:ss.SSS z")
+	}
+	return header + "|" + strings.Join(attributes, "\t")
+}
+
+// sessionUserName return the AD account name of the session user, or the ISE username
+func sessionUserName(sess *Sessions) string {
+	if sess.AdUserSamAccountName != "" {
+		return sess.AdUserSamAccountName
+	}
+	return sess.Username
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSyslogSession() *Sessions {
+	timestamp := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	return &Sessions{
+		Timestamp:            &timestamp,
+		State:                AUTHENTICATED,
+		Username:             "jdoe@example.com",
+		IpAddresses:          []string{"10.0.0.1", "10.0.0.2"},
+		MacAddress:           "AA:BB:CC:DD:EE:FF",
+		NasIpAddress:         "10.1.0.1",
+		AdUserNetBiosName:    "EXAMPLE",
+		AdUserSamAccountName: "jdoe",
+	}
+}
+
+func TestSyslogMessageFraming(t *testing.T) {
+	now := time.Date(2020, 6, 1, 10, 0, 1, 500*int(time.Millisecond), time.UTC)
+	event := &IdentityEvent{Session: &Sessions{Username: "jdoe"}}
+	payload := "CEF:0|Forcepoint|FUID ISE Integration|1.0|identity-login|user login|3|act=login suser=jdoe"
+	header := fmt.Sprintf("<134>1 2020-06-01T10:00:01.500Z fuid-host fuid-ise %d login - ", os.Getpid())
+	udp := &SyslogSink{protocol: "udp", format: SyslogFormatCEF, facility: 16, appName: "fuid-ise", hostname: "fuid-host"}
+	if message := string(udp.formatMessage("login", event, now)); message != header+payload {
+		t.Errorf("the udp message is\n%s\ninstead of\n%s", message, header+payload)
+	}
+	// the stream transports prefix the message with its length in octets
+	for _, protocol := range []string{"tcp", "tls"} {
+		stream := &SyslogSink{protocol: protocol, format: SyslogFormatCEF, facility: 16, appName: "fuid-ise", hostname: "fuid-host"}
+		expected := fmt.Sprintf("%d %s", len(header+payload), header+payload)
+		if message := string(stream.formatMessage("login", event, now)); message != expected {
+			t.Errorf("the %s message is\n%s\ninstead of\n%s", protocol, message, expected)
+		}
+	}
+	// the length counts the octets of the multi-byte characters
+	event = &IdentityEvent{Session: &Sessions{Username: "jérôme"}}
+	stream := &SyslogSink{protocol: "tcp", format: SyslogFormatCEF, facility: 1, appName: "fuid-ise", hostname: "fuid-host"}
+	message := string(stream.formatMessage("logout", event, now))
+	parts := strings.SplitN(message, " ", 2)
+	if parts[0] != fmt.Sprintf("%d", len([]byte(parts[1]))) {
+		t.Errorf("the octet count %s does not match the %d octets of the message", parts[0], len([]byte(parts[1])))
+	}
+	if !strings.HasPrefix(parts[1], "<14>1 ") {
+		t.Errorf("the priority of the facility 1 is not 14: %s", parts[1])
+	}
+}
+
+func TestFormatCEF(t *testing.T) {
+	expected := "CEF:0|Forcepoint|FUID ISE Integration|1.0|identity-login|user login|3|act=login suser=jdoe sntdom=EXAMPLE " +
+		"src=10.0.0.1 cs1Label=ipAddresses cs1=10.0.0.1,10.0.0.2 smac=AA:BB:CC:DD:EE:FF cs2Label=nasIpAddress cs2=10.1.0.1 " +
+		"cs3Label=state cs3=AUTHENTICATED rt=1591005600000"
+	if record := formatCEF("login", newTestSyslogSession()); record != expected {
+		t.Errorf("the CEF record is\n%s\ninstead of\n%s", record, expected)
+	}
+	// the labels of the custom strings without value are not written
+	sess := &Sessions{Username: "jdoe"}
+	expected = "CEF:0|Forcepoint|FUID ISE Integration|1.0|identity-logout|user logout|3|act=logout suser=jdoe"
+	if record := formatCEF("logout", sess); record != expected {
+		t.Errorf("the CEF record is\n%s\ninstead of\n%s", record, expected)
+	}
+	// the extension values escape the backslashes, the equal signs and the line breaks
+	sess = &Sessions{Username: "a=b\\c\r\nd|e", AdUserNetBiosName: "EX AMPLE"}
+	expected = "CEF:0|Forcepoint|FUID ISE Integration|1.0|identity-login|user login|3|act=login suser=a\\=b\\\\c\\r\\nd|e sntdom=EX AMPLE"
+	if record := formatCEF("login", sess); record != expected {
+		t.Errorf("the CEF record is\n%s\ninstead of\n%s", record, expected)
+	}
+	// the header fields escape the backslashes and the pipes
+	if escaped := cefHeaderEscaper.Replace(`a|b\c=d`); escaped != `a\|b\\c=d` {
+		t.Errorf("the CEF header field is escaped as %s", escaped)
+	}
+}
+
+func TestFormatLEEF(t *testing.T) {
+	expected := "LEEF:1.0|Forcepoint|FUID ISE Integration|1.0|identity-login|act=login\tusrName=jdoe\tdomain=EXAMPLE\t" +
+		"src=10.0.0.1\tipAddresses=10.0.0.1,10.0.0.2\tsrcMAC=AA:BB:CC:DD:EE:FF\tnasIpAddress=10.1.0.1\tstate=AUTHENTICATED\t" +
+		"devTime=Jun 01 2020 10:00:00.000 UTC\tdevTimeFormat=MMM dd yyyy HH:mm:ss.SSS z"
+	if record := formatLEEF("login", newTestSyslogSession()); record != expected {
+		t.Errorf("the LEEF record is\n%s\ninstead of\n%s", record, expected)
+	}
+	// the tabs and the line breaks of the values would split the attributes, they are replaced by spaces
+	sess := &Sessions{Username: "a\tb\r\nc=d"}
+	expected = "LEEF:1.0|Forcepoint|FUID ISE Integration|1.0|identity-logout|act=logout\tusrName=a b  c=d"
+	if record := formatLEEF("logout", sess); record != expected {
+		t.Errorf("the LEEF record is\n%q\ninstead of\n%q", record, expected)
+	}
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ServiceLookupTrustSec             = "com.cisco.ise.config.trustsec"
+	TrustSecGetSecurityGroupsEndpoint = "getSecurityGroups"
+)
+
+// SecurityGroup is a TrustSec security group defined in ISE
+type SecurityGroup struct {
+	Id          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Tag         int    `json:"tag"`
+}
+
+type SecurityGroups struct {
+	SecurityGroups []SecurityGroup `json:"securityGroups"`
+}
+
+// SGTGroupMapper turn the TrustSec security group of a session into a pseudo-group DN of the FUID user.
+// the groups starting with the DN prefix and ending with the DN suffix are managed by the mapper
+type SGTGroupMapper struct {
+	prefix   string
+	suffix   string
+	service  *ServiceClient
+	mutex    sync.Mutex
+	refresh  time.Duration
+	loadedAt time.Time
+	names    map[int]string
+}
+
+// NewSGTGroupMapper create the mapper from the SGT_* configs, nil is returned when SGT_GROUPS_ENABLED is false.
+// the TrustSec service is used to map the numeric tags to their names when TRUSTSEC_LOOKUP_ENABLED is true
+func NewSGTGroupMapper(controller *Controller) (*SGTGroupMapper, error) {
+	if !viper.GetBool("SGT_GROUPS_ENABLED") {
+		return nil, nil
+	}
+	mapper := &SGTGroupMapper{
+		prefix:  viper.GetString("SGT_GROUP_DN_PREFIX"),
+		suffix:  viper.GetString("SGT_GROUP_DN_SUFFIX"),
+		refresh: time.Duration(viper.GetInt("TRUSTSEC_REFRESH_INTERVAL")) * time.Minute,
+	}
+	// the managed groups are told apart from the AD groups by the prefix and the suffix
+	if strings.TrimSpace(mapper.prefix) == "" && strings.TrimSpace(mapper.suffix) == "" {
+		return nil, errors.New("SGT_GROUP_DN_PREFIX or SGT_GROUP_DN_SUFFIX is required, without them every group of the users would be replaced by the SGT group")
+	}
+	if viper.GetBool("TRUSTSEC_LOOKUP_ENABLED") && controller != nil {
+		service, err := NewServiceClient(ServiceLookupTrustSec, controller)
+		if err != nil {
+			return nil, err
+		}
+		mapper.service = service
+	}
+	return mapper, nil
+}
+
+// Group return the pseudo-group DN of the session security group, or an empty string when the session has none
+func (m *SGTGroupMapper) Group(sess *Sessions) string {
+	if m == nil {
+		return ""
+	}
+	name := m.securityGroupName(strings.TrimSpace(sess.CtsSecurityGroup))
+	if name == "" {
+		return ""
+	}
+	return m.prefix + name + m.suffix
+}
+
+// Merge replace the managed groups of a group list with the group of the session security group
+func (m *SGTGroupMapper) Merge(groups, current []string, sess *Sessions) []string {
+	if m == nil {
+		return groups
+	}
+	var merged []string
+	for _, group := range groups {
+		if !m.isManaged(group) {
+			merged = append(merged, group)
+		}
+	}
+	if group := m.Group(sess); group != "" {
+		merged = append(merged, group)
+	}
+	return merged
+}
+
+func (m *SGTGroupMapper) isManaged(group string) bool {
+	lowerGroup := strings.ToLower(group)
+	return strings.HasPrefix(lowerGroup, strings.ToLower(m.prefix)) && strings.HasSuffix(lowerGroup, strings.ToLower(m.suffix))
+}
+
+// securityGroupName return the name of a security group, a numeric tag is mapped to its name with the TrustSec service
+func (m *SGTGroupMapper) securityGroupName(value string) string {
+	if value == "" {
+		return ""
+	}
+	tag, err := strconv.ParseInt(value, 0, 32)
+	if err != nil || m.service == nil {
+		return value
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.loadedAt.IsZero() || (m.refresh > 0 && time.Since(m.loadedAt) > m.refresh) {
+		m.loadedAt = time.Now()
+		var securityGroups SecurityGroups
+		if err := m.service.Send(TrustSecGetSecurityGroupsEndpoint, struct{}{}, true, &securityGroups); err != nil {
+			// the previous names are kept until the next refresh, an unknown tag is used as it is
+			logrus.Warningf("cannot read the TrustSec security groups: %s", err)
+		} else {
+			m.names = make(map[int]string, len(securityGroups.SecurityGroups))
+			for _, securityGroup := range securityGroups.SecurityGroups {
+				m.names[securityGroup.Tag] = securityGroup.Name
+			}
+		}
+	}
+	if name, ok := m.names[int(tag)]; ok {
+		return name
+	}
+	return value
+}
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"github.com/spf13/viper"
+	"strings"
+	"testing"
+)
+
+func TestSGTGroupMapperConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("SGT_GROUPS_ENABLED", true)
+	tests := []struct {
+		prefix string
+		suffix string
+		valid  bool
+	}{
+		{"CN=SGT-", "", true},
+		{"", ",OU=TrustSec,DC=example,DC=local", true},
+		{"", "", false},
+		{" ", "", false},
+	}
+	for _, test := range tests {
+		viper.Set("SGT_GROUP_DN_PREFIX", test.prefix)
+		viper.Set("SGT_GROUP_DN_SUFFIX", test.suffix)
+		if _, err := NewSGTGroupMapper(nil); (err == nil) != test.valid {
+			t.Errorf("prefix '%s' and suffix '%s': got %v", test.prefix, test.suffix, err)
+		}
+	}
+}
+
+func TestSGTGroupMapperMerge(t *testing.T) {
+	mapper := &SGTGroupMapper{prefix: "CN=SGT-", suffix: ",OU=TrustSec,DC=example,DC=local"}
+	groups := []string{"CN=Sales,DC=example,DC=local", "cn=sgt-Guests,ou=trustsec,dc=example,dc=local"}
+	merged := mapper.Merge(groups, nil, &Sessions{CtsSecurityGroup: "Employees"})
+	expected := "CN=Sales,DC=example,DC=local;CN=SGT-Employees,OU=TrustSec,DC=example,DC=local"
+	if strings.Join(merged, ";") != expected {
+		t.Errorf("the groups are merged as %v", merged)
+	}
+	// a session without security group removes the managed groups only
+	if merged := mapper.Merge(groups, nil, &Sessions{}); strings.Join(merged, ";") != "CN=Sales,DC=example,DC=local" {
+		t.Errorf("the groups are merged as %v", merged)
+	}
+}
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// TTLCache keep values by key until their TTL expired. the entries are kept in the order they were put, the expired
+// entries at the front are removed on every access so an idle key does not stay in memory, and the oldest entries are
+// evicted once the cache holds maxEntries entries. the values of another TTL than the front ones are removed when they
+// are read or reach the front
+type TTLCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+	// gauge is the metric set to the number of entries, it is not set when empty
+	gauge string
+	// evicted is called with the mutex held for every value removed from the cache, replaced values included
+	evicted func(key string, value interface{})
+}
+
+type ttlCacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewTTLCache create a cache of at most maxEntries entries, zero is unlimited
+func NewTTLCache(maxEntries int, gauge string, evicted func(key string, value interface{})) *TTLCache {
+	return &TTLCache{maxEntries: maxEntries, entries: make(map[string]*list.Element), order: list.New(), gauge: gauge, evicted: evicted}
+}
+
+// Get return the value of a key, ok is false when the key is not cached or expired
+func (c *TTLCache) Get(key string) (value interface{}, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*ttlCacheEntry)
+	if now.After(entry.expires) {
+		c.remove(element)
+		c.updateSize()
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Put cache the value of a key for ttl, the oldest entries are evicted when the cache is full
+func (c *TTLCache) Put(key string, value interface{}, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	for c.maxEntries > 0 && c.order.Len() >= c.maxEntries {
+		c.remove(c.order.Front())
+	}
+	c.entries[key] = c.order.PushBack(&ttlCacheEntry{key: key, value: value, expires: now.Add(ttl)})
+	c.updateSize()
+}
+
+// Remove remove the entry of a key
+func (c *TTLCache) Remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+		c.updateSize()
+	}
+}
+
+// Flush remove every entry, the number of removed entries is returned
+func (c *TTLCache) Flush() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	count := c.order.Len()
+	for c.order.Len() != 0 {
+		c.remove(c.order.Front())
+	}
+	c.updateSize()
+	return count
+}
+
+// Len return the number of entries, the expired entries not removed yet included
+func (c *TTLCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+// sweep remove the expired entries at the front, the mutex must be held
+func (c *TTLCache) sweep(now time.Time) {
+	removed := false
+	for element := c.order.Front(); element != nil && now.After(element.Value.(*ttlCacheEntry).expires); element = c.order.Front() {
+		c.remove(element)
+		removed = true
+	}
+	if removed {
+		c.updateSize()
+	}
+}
+
+// remove an entry, the mutex must be held
+func (c *TTLCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*ttlCacheEntry)
+	delete(c.entries, entry.key)
+	if c.evicted != nil {
+		c.evicted(entry.key, entry.value)
+	}
+}
+
+// updateSize set the size gauge, the mutex must be held
+func (c *TTLCache) updateSize() {
+	if c.gauge != "" {
+		DefaultMetrics.Set(c.gauge, nil, float64(c.order.Len()))
+	}
+}
@@ -2,12 +2,17 @@ package lib
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"encoding/pem"
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net"
 	"os"
-	"os/exec"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -19,8 +24,9 @@ func IsFileExist(filePath string) bool {
 	return true
 }
 
-func GetController() (*Controller, error) {
-	TLSConfig := NewConfig()
+// GetController create the controller of an ISE source
+func GetController(source *ISESource) (*Controller, error) {
+	TLSConfig := NewConfig(source)
 	controller, err := NewControl(TLSConfig)
 	if err != nil {
 		return nil, err
@@ -28,42 +34,68 @@ func GetController() (*Controller, error) {
 	return controller, nil
 }
 
-func ExtractServerCert(host string, port int) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-	c := fmt.Sprintf("openssl s_client -connect %s:%d 2> /dev/null | sed -n '/^-----BEGIN CERTIFICATE-----$/,/^-----END CERTIFICATE-----$/p'", host, port)
-	cmd := exec.CommandContext(ctx, "bash", "-c", c)
-	out, err := cmd.Output()
-	if ctx.Err() == context.DeadlineExceeded {
-		return nil, errors.New(fmt.Sprintf("timeout exceed for extracting the server certificate, ensure the integration host-machine can reach %s", host))
+// ExtractServerCert retrieve the PEM encoded certificates of a TLS server, through the proxy when one applies
+func ExtractServerCert(host string, port int, proxyConfig *ProxyConfig, timeout time.Duration) ([]byte, error) {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := proxyConfig.DialContext(ctx, &net.Dialer{}, address)
 	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.New(fmt.Sprintf("timeout exceed for extracting the server certificate, ensure the integration host-machine can reach %s", host))
+		}
 		return nil, err
 	}
-	return out, err
+	defer conn.Close()
+	// the handshake is bounded by the deadline of the dial context
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, errors.New(fmt.Sprintf("timeout exceed for extracting the server certificate, ensure the integration host-machine can reach %s", host))
+		}
+		return nil, err
+	}
+	var out []byte
+	for _, cert := range tlsConn.ConnectionState().PeerCertificates {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out, nil
 }
 
 func SetupCloseHandler() {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		os.Exit(0)
+		// the logrus exit handlers release the resources such as the HA lease
+		logrus.Exit(0)
 	}()
 }
 
-func FixJson(data []byte, st interface{}) error {
-	upTo := len(string(data)) - 1
-	for {
-		dataString := string(data)
-		newString := dataString[:upTo]
-		newString = newString + "]}"
-		if err := json.Unmarshal([]byte(newString), st); err == nil {
-			return nil
+// GetConfigList read a config value given as a YAML list or as a comma separated string
+func GetConfigList(key string) []string {
+	var values []string
+	var items []string
+	switch value := viper.Get(key).(type) {
+	case []interface{}:
+		for _, item := range value {
+			items = append(items, fmt.Sprintf("%v", item))
 		}
-		upTo = upTo - 1
-		if upTo < 1000 {
-			return errors.New("cannot umarshall sessions")
+	case []string:
+		items = value
+	default:
+		items = strings.Split(viper.GetString(key), ",")
+	}
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
 		}
 	}
+	return values
 }
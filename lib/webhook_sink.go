@@ -0,0 +1,243 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const (
+	TargetWebhook = "WEBHOOK"
+	ProxyWebhook  = "WEBHOOK"
+	// webhookMaxBuffered bound the events kept while the webhook fails, the oldest ones are dropped first
+	webhookMaxBuffered = 10000
+	// DefaultWebhookTemplate is the JSON payload sent for an identity event when WEBHOOK_TEMPLATE is not set
+	DefaultWebhookTemplate = `{"action":{{json .Action}},"user":{{json .UserName}},"domain":{{json .Session.AdUserNetBiosName}},` +
+		`"ipAddresses":{{json .Session.IpAddresses}},"macAddress":{{json .Session.MacAddress}},` +
+		`"nasIpAddress":{{json .Session.NasIpAddress}},"state":{{json .Session.State}},"timestamp":{{json .Session.Timestamp}},` +
+		`"objectGUID":{{json .ObjectGUID}},"groups":{{json .Groups}},"tags":{{json .Tags}}}`
+)
+
+// WebhookEvent is the data passed to the webhook template
+type WebhookEvent struct {
+	Action     string
+	UserName   string
+	ObjectGUID string
+	Groups     []string
+	Source     string
+	Tags       []string
+	Session    *Sessions
+	User       *FUIDUser
+	Ldap       *LdapElement
+}
+
+// WebhookSink POST the identity events as JSON to a webhook, the events are sent in batches
+type WebhookSink struct {
+	mutex           sync.Mutex
+	url             string
+	template        *template.Template
+	secret          []byte
+	signatureHeader string
+	batchSize       int
+	client          *HTTPClient
+	// buffers keep the events of every ISE source apart, a source flushes only the events of its own poll
+	buffers map[string]*webhookBuffer
+}
+
+// webhookBuffer is the buffer of an ISE source
+type webhookBuffer struct {
+	events []json.RawMessage
+	// retained is the number of leading events kept by a failed flush, the events after them are the ones of the current poll
+	retained int
+}
+
+// NewWebhookSink create a webhook sink from the WEBHOOK_* configs
+func NewWebhookSink() (*WebhookSink, error) {
+	webhookUrl := viper.GetString("WEBHOOK_URL")
+	if webhookUrl == "" {
+		return nil, errors.New("the webhook URL WEBHOOK_URL is not provided")
+	}
+	templateText := viper.GetString("WEBHOOK_TEMPLATE")
+	if viper.GetString("WEBHOOK_TEMPLATE_PATH") != "" {
+		templateBytes, err := ioutil.ReadFile(viper.GetString("WEBHOOK_TEMPLATE_PATH"))
+		if err != nil {
+			return nil, err
+		}
+		templateText = string(templateBytes)
+	}
+	if templateText == "" {
+		templateText = DefaultWebhookTemplate
+	}
+	payloadTemplate, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			data, err := json.Marshal(value)
+			return string(data), err
+		},
+		"join": strings.Join,
+	}).Parse(templateText)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid webhook template")
+	}
+	proxyConfig, err := NewProxyConfig(ProxyWebhook)
+	if err != nil {
+		return nil, err
+	}
+	batchSize := viper.GetInt("WEBHOOK_BATCH_SIZE")
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: viper.GetBool("WEBHOOK_TLS_INSECURE_SKIP_VERIFY")}
+	return &WebhookSink{
+		url:             webhookUrl,
+		template:        payloadTemplate,
+		secret:          []byte(viper.GetString("WEBHOOK_HMAC_SECRET")),
+		signatureHeader: viper.GetString("WEBHOOK_SIGNATURE_HEADER"),
+		batchSize:       batchSize,
+		client:          NewHTTPClient(NewHTTPClientConfig(TargetWebhook, proxyConfig), tlsConfig),
+		buffers:         make(map[string]*webhookBuffer),
+	}, nil
+}
+
+func (w *WebhookSink) Name() string {
+	return SinkWebhook
+}
+
+func (w *WebhookSink) Login(event *IdentityEvent) error {
+	return w.add("login", event)
+}
+
+func (w *WebhookSink) Logout(event *IdentityEvent) error {
+	return w.add("logout", event)
+}
+
+func (w *WebhookSink) GroupUpdate(event *IdentityEvent) error {
+	return w.add("group-update", event)
+}
+
+// Flush send the buffered events of a source. the events of a failed batch and the ones after it stay buffered,
+// they are sent again by the next Flush
+func (w *WebhookSink) Flush(source string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.flush(w.buffer(source))
+}
+
+// Reset drop the events of a source buffered since its last flush, the sessions of an aborted poll are read again in the next poll
+func (w *WebhookSink) Reset(source string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	buffer := w.buffer(source)
+	if dropped := len(buffer.events) - buffer.retained; dropped > 0 {
+		logrus.Debugf("dropped %d webhook events of an aborted poll", dropped)
+	}
+	buffer.events = buffer.events[:buffer.retained]
+}
+
+// buffer return the buffer of a source, the mutex must be held
+func (w *WebhookSink) buffer(source string) *webhookBuffer {
+	buffer, ok := w.buffers[source]
+	if !ok {
+		buffer = &webhookBuffer{}
+		w.buffers[source] = buffer
+	}
+	return buffer
+}
+
+// add render an event and buffer it, a full batch is sent right away
+func (w *WebhookSink) add(action string, event *IdentityEvent) error {
+	var payload bytes.Buffer
+	if err := w.template.Execute(&payload, newWebhookEvent(action, event)); err != nil {
+		return errors.Wrap(err, "cannot render the webhook template")
+	}
+	if !json.Valid(payload.Bytes()) {
+		return errors.Errorf("the webhook template rendered an invalid JSON payload: %s", payload.String())
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	buffer := w.buffer(event.Source)
+	buffer.events = append(buffer.events, payload.Bytes())
+	if len(buffer.events) >= w.batchSize {
+		return w.flush(buffer)
+	}
+	return nil
+}
+
+// flush send the events of a buffer in batches, the mutex must be held
+func (w *WebhookSink) flush(buffer *webhookBuffer) error {
+	for len(buffer.events) != 0 {
+		size := w.batchSize
+		if size > len(buffer.events) {
+			size = len(buffer.events)
+		}
+		if err := w.post(buffer.events[:size]); err != nil {
+			if dropped := len(buffer.events) - webhookMaxBuffered; dropped > 0 {
+				logrus.Errorf("dropped the %d oldest webhook events, at most %d events are kept while the webhook fails", dropped, webhookMaxBuffered)
+				buffer.events = buffer.events[dropped:]
+			}
+			buffer.retained = len(buffer.events)
+			return err
+		}
+		buffer.events = buffer.events[size:]
+	}
+	buffer.events = nil
+	buffer.retained = 0
+	return nil
+}
+
+// post send a batch, a batch size of one sends the event object, otherwise a JSON array of events
+func (w *WebhookSink) post(batch []json.RawMessage) error {
+	var body []byte
+	var err error
+	if w.batchSize == 1 {
+		body = batch[0]
+	} else if body, err = json.Marshal(batch); err != nil {
+		return err
+	}
+	request := &HTTPRequest{Method: http.MethodPost, Url: w.url, Body: body, Header: http.Header{}, Idempotent: true}
+	if len(w.secret) != 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		request.Header.Set(w.signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("webhook %s responded with status_code: %d, statusReason: %s", w.url, resp.StatusCode, resp.Status)
+	}
+	return nil
+}
+
+// newWebhookEvent build the template data, the GUID and groups come from AD when it was read, otherwise from FUID
+func newWebhookEvent(action string, event *IdentityEvent) *WebhookEvent {
+	webhookEvent := &WebhookEvent{
+		Action:   action,
+		UserName: sessionUserName(event.Session),
+		Source:   event.Source,
+		Tags:     event.Tags,
+		Session:  event.Session,
+		User:     event.User,
+		Ldap:     event.LdapElement,
+	}
+	if event.LdapElement != nil {
+		webhookEvent.ObjectGUID = event.LdapElement.Attributes.ObjectGUID
+		webhookEvent.Groups = event.LdapElement.Attributes.MemberOf
+	} else if event.User != nil {
+		webhookEvent.ObjectGUID = event.User.ObjectGUID
+		webhookEvent.Groups = event.User.Groups
+	}
+	return webhookEvent
+}